@@ -0,0 +1,55 @@
+package apns
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff computes the wait before a retry of a failed operation.
+type Backoff interface {
+	// Next returns the wait before the retry, the attempt is 1 for the first retry.
+	Next(attempt int) time.Duration
+}
+
+// ConstantBackoff waits the same duration before each retry.
+type ConstantBackoff time.Duration
+
+// Next implements Backoff.
+func (b ConstantBackoff) Next(int) time.Duration {
+	return time.Duration(b)
+}
+
+// ExponentialBackoff doubles the wait before each retry, starting with Base, up to Max, and applies full jitter:
+// the actual wait is random between 0 and the computed one, so retries of concurrent sends are spread out.
+// An attempt less than 1 is treated as the first one.
+type ExponentialBackoff struct {
+	Base time.Duration
+	Max  time.Duration
+	// Rand returns a pseudo-random number in [0.0, 1.0), e.g. a fixed one in tests. If nil, [rand.Float64] is used.
+	Rand func() float64
+}
+
+// Next implements Backoff.
+func (b ExponentialBackoff) Next(attempt int) time.Duration {
+	wait := b.Base
+	for i := 1; i < attempt && wait > 0 && wait < b.Max; i++ {
+		if wait > b.Max/2 {
+			// Saturate, the doubled wait exceeds Max or even overflows.
+			wait = b.Max
+			break
+		}
+		wait *= 2
+	}
+	if wait > b.Max {
+		wait = b.Max
+	}
+	if wait < 0 {
+		wait = 0
+	}
+
+	random := b.Rand
+	if random == nil {
+		random = rand.Float64
+	}
+	return time.Duration(random() * float64(wait))
+}
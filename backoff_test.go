@@ -0,0 +1,86 @@
+package apns
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExponentialBackoff(t *testing.T) {
+	b := ExponentialBackoff{
+		Base: 100 * time.Millisecond,
+		Max:  time.Second,
+		Rand: func() float64 { return 0.5 },
+	}
+	var delays []time.Duration
+	for attempt := 1; attempt <= 6; attempt++ {
+		delays = append(delays, b.Next(attempt))
+	}
+	assert.Equal(t, []time.Duration{
+		50 * time.Millisecond,
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		500 * time.Millisecond,
+		500 * time.Millisecond,
+	}, delays)
+	assert.Equal(t, 500*time.Millisecond, b.Next(100))
+	assert.Equal(t, 50*time.Millisecond, b.Next(0))
+	assert.Equal(t, 50*time.Millisecond, b.Next(-1))
+
+	// The doubled wait overflows, it saturates at Max.
+	b = ExponentialBackoff{Base: time.Hour, Max: 1 << 62, Rand: func() float64 { return 0.5 }}
+	for _, attempt := range []int{30, 63, 64, 100, math.MaxInt} {
+		assert.Equal(t, time.Duration(1<<61), b.Next(attempt))
+	}
+	b.Base, b.Max = 100*time.Millisecond, time.Second
+
+	b.Rand = nil
+	for i := 0; i < 100; i++ {
+		d := b.Next(2)
+		assert.True(t, d >= 0 && d < 200*time.Millisecond, d.String())
+	}
+
+	assert.Equal(t, 3*time.Second, ConstantBackoff(3*time.Second).Next(5))
+}
+
+func TestWithBackoff(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	c, err := New(
+		WithJWT(testPrivateKey, "key_id", "issuer"),
+		WithEndpoint(server.URL),
+		WithRetry(10),
+		WithBackoff(time.Hour, time.Hour),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, ExponentialBackoff{Base: time.Hour, Max: time.Hour}, c.retryBackoff)
+	c.retryBackoff = ExponentialBackoff{Base: time.Hour, Max: time.Hour, Rand: func() float64 { return 0.5 }}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = c.Send(ctx, "test-token", Payload{})
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.True(t, time.Since(start) < time.Second)
+
+	// The context is done before the wait, e.g. during the request.
+	ctx, cancel = context.WithCancel(context.Background())
+	c.http = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		cancel()
+		return http.DefaultTransport.RoundTrip(req.WithContext(context.Background()))
+	})}
+	_, err = c.Send(ctx, "test-token", Payload{})
+	assert.Equal(t, context.Canceled, err)
+
+	_, err = New(WithBackoff(time.Second, time.Millisecond))
+	assert.Error(t, err)
+}
@@ -0,0 +1,221 @@
+package apns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+)
+
+// defaultMaxConcurrentSends matches the number of concurrent streams APNs typically allows per connection.
+const defaultMaxConcurrentSends = 1000
+
+// broadcastChunkSize is the number of notifications sent between progress reports of a broadcast.
+const broadcastChunkSize = 1000
+
+// Progress reports advancement of a broadcast.
+type Progress struct {
+	// Total is the number of device tokens in the broadcast.
+	Total int
+	// Sent is the number of notifications accepted by APNs.
+	Sent int
+	// Failed is the number of notifications, that were rejected or could not be sent.
+	Failed int
+	// TimedOut is the number of notifications, that APNs did not respond to in time, e.g. due to
+	// [WithRequestTimeout]. Unlike the failed ones, they may have been delivered.
+	TimedOut int
+	// Duplicates are the device tokens, that were passed more than once and sent only once, if [WithDedupBatch]
+	// is used. Total does not include the repeated ones.
+	Duplicates []string
+}
+
+// SendBroadcast sends the notification to all device tokens in chunks, and reports progress on the returned channel
+// after each chunk. The channel holds only the latest progress, so a slow reader skips intermediate reports, and
+// it is closed when the broadcast completes. If the context is done, the broadcast stops and the last progress
+// reports the notifications sent so far.
+func (c *Client) SendBroadcast(ctx context.Context, tokens []string, p Payload, opts ...SendOption) (<-chan Progress, error) {
+	// Fail fast on a payload, that can not be sent to any device.
+	if _, err := json.Marshal(p); err != nil {
+		return nil, err
+	}
+
+	var duplicates []string
+	if c.dedupBatch {
+		tokens, duplicates = dedupTokens(tokens)
+	}
+
+	ch := make(chan Progress, 1)
+	go func() {
+		defer close(ch)
+
+		var mtx sync.Mutex
+		progress := Progress{Total: len(tokens), Duplicates: duplicates}
+		for start := 0; start < len(tokens) && ctx.Err() == nil; start += broadcastChunkSize {
+			end := start + broadcastChunkSize
+			if end > len(tokens) {
+				end = len(tokens)
+			}
+			c.sendEach(ctx, tokens[start:end], p, opts, func(_ int, _ *Response, err error) {
+				if ctx.Err() != nil && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
+					// Not sent, because the broadcast is stopped.
+					return
+				}
+				mtx.Lock()
+				switch {
+				case err == nil:
+					progress.Sent++
+				case errors.Is(err, ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+					progress.TimedOut++
+				default:
+					progress.Failed++
+				}
+				mtx.Unlock()
+			})
+
+			mtx.Lock()
+			report := progress
+			mtx.Unlock()
+			// Replace the unread report, so the broadcast is never blocked by the reader.
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- report
+		}
+	}()
+	return ch, nil
+}
+
+// Result is the result of sending a notification to a device token by [Client.SendMany].
+type Result struct {
+	DeviceToken string
+	Response    *Response
+	Err         error
+}
+
+// SendMany sends the notification to all device tokens concurrently, and returns the results in the order of
+// the tokens once all sends complete. If the context is done, the results of the tokens, that were not sent,
+// have the context error.
+func (c *Client) SendMany(ctx context.Context, tokens []string, p Payload, opts ...SendOption) []Result {
+	results := make([]Result, len(tokens))
+	c.sendEach(ctx, tokens, p, opts, func(i int, resp *Response, err error) {
+		results[i] = Result{DeviceToken: tokens[i], Response: resp, Err: err}
+	})
+	return results
+}
+
+// dedupTokens returns the unique device tokens in the original order, and the tokens, that were repeated.
+func dedupTokens(tokens []string) (unique []string, duplicates []string) {
+	seen := make(map[string]bool, len(tokens))
+	unique = make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		if seen[token] {
+			duplicates = append(duplicates, token)
+			continue
+		}
+		seen[token] = true
+		unique = append(unique, token)
+	}
+	return unique, duplicates
+}
+
+// Environment is the APNs environment a device token belongs to.
+type Environment int
+
+// Possible environments of a device token.
+const (
+	EnvironmentUnknown Environment = iota
+	EnvironmentProduction
+	EnvironmentSandbox
+)
+
+func (e Environment) String() string {
+	switch e {
+	case EnvironmentProduction:
+		return "production"
+	case EnvironmentSandbox:
+		return "sandbox"
+	default:
+		return "unknown"
+	}
+}
+
+// SplitByEnvironment classifies device tokens stored without their environment. It sends the notification to each
+// token via the production client, then the tokens production rejected as bad ones via the sandbox client, and
+// returns the environment, that accepted each token. Tokens rejected as bad by both are reported as
+// EnvironmentUnknown. Tokens, whose environment could not be determined, e.g. because of a server or connection
+// error, an unregistered token or a done context, are not probed further and are returned in failed with
+// the error of the last send, so they can be retried later. Note, that the notification is delivered to
+// the accepted devices, so a background notification is usually used.
+func SplitByEnvironment(ctx context.Context, production, sandbox *Client, tokens []string, p Payload, opts ...SendOption) (envs map[string]Environment, failed map[string]error) {
+	envs = make(map[string]Environment, len(tokens))
+	failed = make(map[string]error)
+	var mtx sync.Mutex
+
+	var rejected []string
+	production.sendEach(ctx, tokens, p, opts, func(i int, _ *Response, err error) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		switch {
+		case err == nil:
+			envs[tokens[i]] = EnvironmentProduction
+		case isEnvironmentRejection(err):
+			rejected = append(rejected, tokens[i])
+		default:
+			failed[tokens[i]] = err
+		}
+	})
+
+	sandbox.sendEach(ctx, rejected, p, opts, func(i int, _ *Response, err error) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		switch {
+		case err == nil:
+			envs[rejected[i]] = EnvironmentSandbox
+		case isEnvironmentRejection(err):
+			envs[rejected[i]] = EnvironmentUnknown
+		default:
+			failed[rejected[i]] = err
+		}
+	})
+	return envs, failed
+}
+
+// isEnvironmentRejection reports whether APNs rejected the device token, as it may belong to the other
+// environment.
+func isEnvironmentRejection(err error) bool {
+	var reasonErr *UnknownReasonError
+	if errors.As(err, &reasonErr) {
+		return reasonErr.Reason == "BadEnvironmentKeyInToken"
+	}
+	return errors.Is(err, ErrBadDeviceToken)
+}
+
+// sendEach sends the notification to each device token concurrently, running at most c.maxConcurrentSends sends
+// at once, and calls fn with the index of the token and the result. The fn is called concurrently. Once
+// the context is done, no new sends are started and fn is called with the context error for the rest.
+func (c *Client) sendEach(ctx context.Context, tokens []string, p Payload, opts []SendOption, fn func(i int, resp *Response, err error)) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, c.maxConcurrentSends)
+	for i, token := range tokens {
+		if ctx.Err() != nil {
+			fn(i, nil, ctx.Err())
+			continue
+		}
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			fn(i, nil, ctx.Err())
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, token string) {
+			defer wg.Done()
+			resp, err := c.Send(ctx, token, p, opts...)
+			<-sem
+			fn(i, resp, err)
+		}(i, token)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,197 @@
+package apns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendBroadcast(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if strings.HasSuffix(req.URL.Path, "/bad") {
+			rw.WriteHeader(http.StatusBadRequest)
+			rw.Write([]byte(`{"reason": "BadDeviceToken"}`))
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	c, err := NewClient(
+		context.Background(),
+		WithJWT(testPrivateKey, "key_id", "issuer"),
+		WithEndpoint(server.URL),
+	)
+	assert.NoError(t, err)
+
+	tokens := make([]string, 0, broadcastChunkSize+10)
+	for i := 0; i < broadcastChunkSize+8; i++ {
+		tokens = append(tokens, fmt.Sprintf("token-%d", i))
+	}
+	tokens = append(tokens, "bad", "bad")
+
+	ch, err := c.SendBroadcast(context.Background(), tokens, Payload{})
+	assert.NoError(t, err)
+
+	var last Progress
+	for p := range ch {
+		assert.Equal(t, p.Total, len(tokens))
+		last = p
+	}
+	assert.Equal(t, last, Progress{Total: len(tokens), Sent: broadcastChunkSize + 8, Failed: 2})
+
+	stats := c.Stats()
+	assert.Equal(t, stats.Sent, uint64(broadcastChunkSize+8))
+	assert.Equal(t, stats.Failed, uint64(2))
+	assert.True(t, stats.Rate > 0)
+}
+
+func TestSendBroadcastTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if strings.HasSuffix(req.URL.Path, "/slow") {
+			time.Sleep(500 * time.Millisecond)
+		}
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	c, err := NewClient(
+		context.Background(),
+		WithJWT(testPrivateKey, "key_id", "issuer"),
+		WithEndpoint(server.URL),
+		WithRequestTimeout(200*time.Millisecond),
+	)
+	assert.NoError(t, err)
+
+	ch, err := c.SendBroadcast(context.Background(), []string{"a", "slow", "b"}, Payload{})
+	assert.NoError(t, err)
+
+	var last Progress
+	for p := range ch {
+		last = p
+	}
+	assert.Equal(t, last, Progress{Total: 3, Sent: 2, TimedOut: 1})
+}
+
+func TestSendBroadcastDedupBatch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	c, err := NewClient(
+		context.Background(),
+		WithJWT(testPrivateKey, "key_id", "issuer"),
+		WithEndpoint(server.URL),
+		WithDedupBatch(),
+	)
+	assert.NoError(t, err)
+
+	ch, err := c.SendBroadcast(context.Background(), []string{"a", "b", "a", "c", "b", "a"}, Payload{})
+	assert.NoError(t, err)
+
+	var last Progress
+	for p := range ch {
+		last = p
+	}
+	assert.Equal(t, last, Progress{Total: 3, Sent: 3, Duplicates: []string{"a", "b", "a"}})
+	assert.Equal(t, c.Stats().Sent, uint64(3))
+}
+
+func TestSendMany(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if strings.HasSuffix(req.URL.Path, "/bad") {
+			rw.WriteHeader(http.StatusBadRequest)
+			rw.Write([]byte(`{"reason": "BadDeviceToken"}`))
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	c, err := NewClient(
+		context.Background(),
+		WithJWT(testPrivateKey, "key_id", "issuer"),
+		WithEndpoint(server.URL),
+	)
+	assert.NoError(t, err)
+
+	results := c.SendMany(context.Background(), []string{"a", "bad", "b"}, Payload{})
+	assert.Equal(t, len(results), 3)
+	for i, token := range []string{"a", "bad", "b"} {
+		assert.Equal(t, results[i].DeviceToken, token)
+		assert.NotNil(t, results[i].Response)
+	}
+	assert.NoError(t, results[0].Err)
+	assert.Equal(t, results[1].Err, ErrBadDeviceToken)
+	assert.NoError(t, results[2].Err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results = c.SendMany(ctx, []string{"a", "b"}, Payload{})
+	for _, r := range results {
+		assert.Equal(t, r.Err, context.Canceled)
+	}
+}
+
+func TestSplitByEnvironment(t *testing.T) {
+	var sandboxRequests int32
+	newServer := func(accepted string, requests *int32) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			if requests != nil {
+				atomic.AddInt32(requests, 1)
+			}
+			switch {
+			case strings.HasSuffix(req.URL.Path, "/"+accepted):
+				rw.WriteHeader(http.StatusOK)
+			case strings.HasSuffix(req.URL.Path, "/busy"):
+				rw.WriteHeader(http.StatusServiceUnavailable)
+				rw.Write([]byte(`{"reason": "ServiceUnavailable"}`))
+			case strings.HasSuffix(req.URL.Path, "/inactive"):
+				rw.WriteHeader(http.StatusGone)
+				rw.Write([]byte(`{"reason": "Unregistered"}`))
+			default:
+				rw.WriteHeader(http.StatusBadRequest)
+				rw.Write([]byte(`{"reason": "BadDeviceToken"}`))
+			}
+		}))
+	}
+	productionServer := newServer("prod", nil)
+	defer productionServer.Close()
+	sandboxServer := newServer("dev", &sandboxRequests)
+	defer sandboxServer.Close()
+
+	production, err := NewClient(context.Background(),
+		WithJWT(testPrivateKey, "key_id", "issuer"), WithEndpoint(productionServer.URL))
+	assert.NoError(t, err)
+	sandbox, err := NewClient(context.Background(),
+		WithJWT(testPrivateKey, "key_id", "issuer"), WithEndpoint(sandboxServer.URL))
+	assert.NoError(t, err)
+
+	envs, failed := SplitByEnvironment(context.Background(), production, sandbox,
+		[]string{"prod", "dev", "gone", "busy", "inactive"}, Payload{APS: APS{ContentAvailable: Pointer(1)}})
+	assert.Equal(t, envs, map[string]Environment{
+		"prod": EnvironmentProduction,
+		"dev":  EnvironmentSandbox,
+		"gone": EnvironmentUnknown,
+	})
+	assert.Equal(t, len(failed), 2)
+	assert.True(t, IsTemporary(failed["busy"]))
+	assert.True(t, errors.Is(failed["inactive"], ErrUnregistered))
+	// Only the tokens rejected as bad ones are probed in sandbox.
+	assert.Equal(t, atomic.LoadInt32(&sandboxRequests), int32(2))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	envs, failed = SplitByEnvironment(ctx, production, sandbox, []string{"prod"}, Payload{})
+	assert.Empty(t, envs)
+	assert.Equal(t, failed, map[string]error{"prod": context.Canceled})
+}
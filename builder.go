@@ -0,0 +1,65 @@
+package apns
+
+// PayloadBuilder builds a Payload with chained calls, that set the pointer fields, e.g.
+//
+//	p := apns.NewPayload().Alert("Hello", "World").Badge(1).Sound("default").Build()
+type PayloadBuilder struct {
+	payload Payload
+}
+
+// NewPayload creates new PayloadBuilder of an empty payload.
+func NewPayload() *PayloadBuilder {
+	return &PayloadBuilder{}
+}
+
+// Alert sets the title and the body of the alert.
+func (b *PayloadBuilder) Alert(title, body string) *PayloadBuilder {
+	b.payload.APS.Alert.Title = title
+	b.payload.APS.Alert.Body = body
+	return b
+}
+
+// Badge sets the number to display on the app icon, 0 removes the badge.
+func (b *PayloadBuilder) Badge(n int) *PayloadBuilder {
+	b.payload.APS.Badge = Pointer(n)
+	return b
+}
+
+// Sound sets the name of a sound file to play as an alert.
+func (b *PayloadBuilder) Sound(s string) *PayloadBuilder {
+	b.payload.APS.Sound = s
+	return b
+}
+
+// ContentAvailable marks the notification as a background update notification.
+func (b *PayloadBuilder) ContentAvailable() *PayloadBuilder {
+	b.payload.APS.ContentAvailable = Pointer(1)
+	return b
+}
+
+// MutableContent lets the notification service extension of the app modify the notification.
+func (b *PayloadBuilder) MutableContent() *PayloadBuilder {
+	b.payload.APS.MutableContent = Pointer(1)
+	return b
+}
+
+// Custom sets the custom value of the key, that is sent next to the aps dictionary.
+func (b *PayloadBuilder) Custom(key string, value any) *PayloadBuilder {
+	if b.payload.CustomValues == nil {
+		b.payload.CustomValues = make(map[string]any)
+	}
+	b.payload.CustomValues[key] = value
+	return b
+}
+
+// Build returns the payload. The builder can be reused, further calls do not change the returned payload.
+func (b *PayloadBuilder) Build() Payload {
+	p := b.payload
+	if b.payload.CustomValues != nil {
+		p.CustomValues = make(map[string]any, len(b.payload.CustomValues))
+		for k, v := range b.payload.CustomValues {
+			p.CustomValues[k] = v
+		}
+	}
+	return p
+}
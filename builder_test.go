@@ -0,0 +1,32 @@
+package apns
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPayloadBuilder(t *testing.T) {
+	b := NewPayload().
+		Alert("Hello", "World").
+		Badge(0).
+		Sound("default").
+		MutableContent().
+		Custom("key", "value")
+	p := b.Build()
+	assert.NoError(t, p.Validate())
+
+	data, err := json.Marshal(p)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"aps":{"alert":{"title":"Hello","body":"World"},"badge":0,"sound":"default",`+
+		`"mutable-content":1},"key":"value"}`, string(data))
+
+	// The built payload is not changed by the reused builder.
+	b.Custom("key", "other")
+	assert.Equal(t, "value", p.CustomValues["key"])
+
+	data, err = json.Marshal(NewPayload().ContentAvailable().Build())
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"aps":{"content-available":1}}`, string(data))
+}
@@ -0,0 +1,65 @@
+package apns
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// CategoryRegistry holds identifiers of the notification categories, that the app registers on the device.
+// Registered identifiers are meant to be kept in variables, so payloads refer to them instead of repeated
+// string literals:
+//
+//	var categories = apns.NewCategoryRegistry()
+//	var CategoryMessage = categories.MustRegister("MESSAGE")
+//
+//	p := apns.Payload{APS: apns.APS{Category: CategoryMessage}}
+type CategoryRegistry struct {
+	mtx sync.RWMutex
+	ids map[string]struct{}
+}
+
+// NewCategoryRegistry creates new empty CategoryRegistry.
+func NewCategoryRegistry() *CategoryRegistry {
+	return &CategoryRegistry{
+		ids: make(map[string]struct{}),
+	}
+}
+
+// Register registers the category identifier and returns it.
+func (r *CategoryRegistry) Register(id string) (string, error) {
+	if strings.TrimSpace(id) == "" {
+		return "", errors.New("invalid category identifier")
+	}
+
+	r.mtx.Lock()
+	r.ids[id] = struct{}{}
+	r.mtx.Unlock()
+	return id, nil
+}
+
+// MustRegister is like Register, but panics if the identifier is invalid. It simplifies initialization of
+// package-level variables.
+func (r *CategoryRegistry) MustRegister(id string) string {
+	id, err := r.Register(id)
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+// Validate checks that the category of the payload, if any, is registered.
+func (r *CategoryRegistry) Validate(p Payload) error {
+	if p.APS.Category == "" {
+		return nil
+	}
+
+	r.mtx.RLock()
+	_, ok := r.ids[p.APS.Category]
+	r.mtx.RUnlock()
+	if !ok {
+		return fmt.Errorf("category %q is not registered", p.APS.Category)
+	}
+	return nil
+}
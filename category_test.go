@@ -0,0 +1,19 @@
+package apns
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCategoryRegistry(t *testing.T) {
+	r := NewCategoryRegistry()
+	message := r.MustRegister("MESSAGE")
+
+	_, err := r.Register(" ")
+	assert.Error(t, err)
+
+	assert.NoError(t, r.Validate(Payload{APS: APS{Category: message}}))
+	assert.NoError(t, r.Validate(Payload{}))
+	assert.EqualError(t, r.Validate(Payload{APS: APS{Category: "MESAGE"}}), `category "MESAGE" is not registered`)
+}
@@ -4,13 +4,20 @@ import (
 	"bytes"
 	"context"
 	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
+	"golang.org/x/net/http2"
 )
 
 // APN service endpoint URLs.
@@ -19,75 +26,482 @@ const (
 	ProductionGateway = "https://api.push.apple.com"
 )
 
-var (
-	defaultTokenRenewInterval    = 10 * time.Minute
-	defaultTokenValidityInterval = time.Hour
-)
+// maxResponseBodySize limits the size of a response body that is read. APNs responds with tiny JSON bodies,
+// larger ones can only come from a misbehaving intermediary.
+const maxResponseBodySize = 4 << 10
+
+// defaultTokenValidityInterval is the validity of the provider token, APNs rejects tokens older than an hour.
+const defaultTokenValidityInterval = time.Hour
+
+// defaultTokenRenewRetryInterval is the wait before the retry of a failed token renewal, if neither
+// [WithTokenRenewBackoff] nor [WithTokenRenewInterval] is used.
+const defaultTokenRenewRetryInterval = time.Minute
+
+// defaultRequestTimeout limits requests, that have no deadline, so a stalled connection can not hang them forever.
+const defaultRequestTimeout = 30 * time.Second
+
+// minTokenRefreshInterval is the minimal interval between provider token refreshes, that APNs does not throttle.
+const minTokenRefreshInterval = 20 * time.Minute
+
+// maxClockSkew is the tolerated difference between the issue time of the provider token and the local clock.
+const maxClockSkew = time.Minute
 
 // JWTConfig represents configuration to generate JWT.
 type JWTConfig struct {
 	PrivateKey *ecdsa.PrivateKey
 	Issuer     string
 	KeyID      string
+	// SigningMethod signs the token. If nil, it is selected by the curve of the private key.
+	SigningMethod jwt.SigningMethod
 }
 
 // Client represents the Apple Push Notification Service that you send notifications to.
 type Client struct {
+	name      string
 	http      *http.Client
 	endpoint  string
+	endpoints *endpointSelector
+	encoder   Encoder
 	jwtConfig *JWTConfig
+	cert      *tls.Certificate
+
+	mtx           sync.RWMutex
+	sendOpts      map[string]SendOption
+	token         string
+	tokenIssuedAt time.Time
+	started       bool
+
+	done      chan struct{}
+	closeOnce sync.Once
+	// wg tracks the background goroutines, so Close returns once they are stopped.
+	wg sync.WaitGroup
+
+	tokenManager        *TokenManager
+	tokenTeamID         string
+	tokenRenewedHandler func(token string, expiresAt time.Time)
+	tokenErrorHandler   func(err error)
+	lazyTokenRenewal    time.Duration
+	tokenValidity       time.Duration
+	signingMethod       jwt.SigningMethod
+	tokenRenewInterval  time.Duration
+	tokenRenewBackoff   Backoff
+
+	topicValidator         func(topic string) error
+	deadlineFromExpiration bool
+	captureResponseBody    bool
+	connTrace              bool
+	collapseIDFunc         func(p Payload) string
+	dedup                  *dedupCache
+	maxConcurrentSends     int
+	validatePayload        bool
+	errorDecoder           func(status int, body []byte) error
+	rateLimiter            *rateLimiter
 
-	mtx      sync.RWMutex
-	sendOpts map[string]SendOption
+	tokenLifecycleHandler func(token string, event TokenEvent)
+	coalescer             *coalescer
+	retryPolicy           RetryPolicy
+	retryBackoff          Backoff
+	requestTimeout        time.Duration
+	operationTimeout      time.Duration
+	priorityTimeouts      map[int]time.Duration
+	noDefaultTimeout      bool
+	priorityIsolation     bool
+	requireResponseID     bool
+	successCodes          map[int]bool
+	dedupBatch            bool
+	priorityHTTP          *http.Client
+
+	curlDump        io.Writer
+	verboseLog      io.Writer
+	dumpMtx         sync.Mutex
+	unredactedDumps bool
+	roundTripHook   func(req *http.Request, resp *http.Response, err error, latency time.Duration)
+
+	topic         string
+	pushType      string
+	inferPushType bool
+	minOS         osVersion
+	redactTokens  bool
+
+	stats      stats
+	expvarName string
+
+	logger     *slog.Logger
+	deprecated []deprecation
 }
 
-// NewClient creates new AONS client based on defined Options.
+// deprecation is a deprecated option, that is used.
+type deprecation struct {
+	option      string
+	replacement string
+}
+
+// NewClient creates new APNS client based on defined Options and starts the token renewal, that runs until
+// the context is done or the client is closed. It is [New] followed by [Client.Start].
 func NewClient(ctx context.Context, opts ...ClientOption) (*Client, error) {
+	c, err := New(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Start(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// New creates new APNS client based on defined Options. Unlike [NewClient], it does not start the token renewal:
+// call [Client.Start] to begin it and [Client.Close] to stop.
+func New(opts ...ClientOption) (*Client, error) {
+	transport := &http.Transport{}
 	c := &Client{
 		http: &http.Client{
-			Transport: &http.Transport{},
+			Transport: transport,
 		},
 		endpoint: ProductionGateway,
+		encoder:  APNsEncoder{},
 		sendOpts: make(map[string]SendOption),
+		done:     make(chan struct{}),
+
+		maxConcurrentSends: defaultMaxConcurrentSends,
+		tokenValidity:      defaultTokenValidityInterval,
+		logger:             slog.New(discardHandler{}),
 	}
 	for _, o := range opts {
 		if err := o(c); err != nil {
 			return nil, err
 		}
 	}
+	if c.tokenRenewInterval >= c.tokenValidity {
+		return nil, errors.New("token renew interval must be less than the token validity")
+	}
+	if c.lazyTokenRenewal >= c.tokenValidity {
+		return nil, errors.New("token max age must be less than the token validity")
+	}
+	if c.jwtConfig != nil && c.signingMethod != nil {
+		// The first token is issued on the first send, so the key is checked now.
+		if m, ok := c.signingMethod.(*jwt.SigningMethodECDSA); ok && m.CurveBits != c.jwtConfig.PrivateKey.Curve.Params().BitSize {
+			return nil, fmt.Errorf("signing method %s does not match curve %s of private key",
+				m.Alg(), c.jwtConfig.PrivateKey.Curve.Params().Name)
+		}
+		c.jwtConfig.SigningMethod = c.signingMethod
+	}
+	if c.name != "" {
+		c.logger = c.logger.With("client", c.name)
+	}
+	for _, d := range c.deprecated {
+		c.logger.Warn("apns: deprecated option is used", "option", d.option, "replacement", d.replacement)
+	}
+	if c.jwtConfig != nil && c.tokenManager != nil {
+		return nil, errors.New("JWT and token manager can not be used together")
+	}
+	if c.topic != "" && c.pushType != "" {
+		if err := validateTopic(c.topic, c.pushType); err != nil {
+			return nil, err
+		}
+	}
+	if c.expvarName != "" {
+		if err := c.publishExpvar(c.expvarName); err != nil {
+			return nil, err
+		}
+	}
 
-	if c.jwtConfig != nil {
-		go c.renewToken(ctx, defaultTokenRenewInterval)
+	if c.http.Transport == transport {
+		// HTTP/2 of x/net reports GOAWAY as http2.GoAwayError, so unprocessed requests can be retried.
+		if _, err := http2.ConfigureTransports(transport); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.priorityIsolation {
+		t, ok := c.http.Transport.(*http.Transport)
+		if !ok {
+			return nil, errors.New("priority isolation requires *http.Transport")
+		}
+		priority := t.Clone()
+		if t == transport {
+			// The clone shares HTTP/2 connections of the default transport, unless it is configured anew.
+			priority.TLSNextProto = nil
+			if _, err := http2.ConfigureTransports(priority); err != nil {
+				return nil, err
+			}
+		}
+		c.priorityHTTP = &http.Client{
+			Transport: priority,
+			Timeout:   c.http.Timeout,
+		}
 	}
 
+	if c.coalescer != nil {
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			c.coalescer.run(c.done, &c.wg)
+		}()
+	}
 	return c, nil
 }
 
-// Send sends Notification to the APN service.
+// Start begins the background renewal of the provider token, that runs until the context is done or the client
+// is closed. It can be called only once.
+func (c *Client) Start(ctx context.Context) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.started {
+		return errors.New("client is already started")
+	}
+	select {
+	case <-c.done:
+		return errors.New("client is closed")
+	default:
+	}
+	c.started = true
+
+	if c.jwtConfig != nil && c.lazyTokenRenewal == 0 {
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			c.renewToken(ctx)
+		}()
+	}
+	return nil
+}
+
+// Close stops the token renewal and closes idle connections. It returns once the background goroutines are
+// stopped, so it must not be called from handlers, that are called by them, e.g. [WithTokenRenewedHandler].
+// It is safe to call Close multiple times.
+func (c *Client) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+	c.wg.Wait()
+	c.CloseIdleConnections()
+	return nil
+}
+
+// CloseIdleConnections closes idle connections to APNs, without stopping the client, e.g. before a long idle period,
+// so half-open connections are not reused after it. Next sends open new connections.
+func (c *Client) CloseIdleConnections() {
+	c.http.CloseIdleConnections()
+	if c.priorityHTTP != nil {
+		c.priorityHTTP.CloseIdleConnections()
+	}
+}
+
+// Name returns the name of the client set by [WithName].
+func (c *Client) Name() string {
+	return c.name
+}
+
+// Validate checks the configuration of the client without network requests: auth is configured, the provider
+// token is signed with a key on a supported curve and is not issued in the future, the certificate is not expired, and the topic
+// is set, if token-based auth is used. It returns all found problems joined.
+func (c *Client) Validate() error {
+	var errs []error
+	if c.jwtConfig == nil && c.tokenManager == nil && c.cert == nil {
+		errs = append(errs, errors.New("no auth configured"))
+	}
+
+	if c.jwtConfig != nil {
+		if _, err := signingMethodFor(c.jwtConfig.PrivateKey.Curve); err != nil {
+			errs = append(errs, err)
+		}
+		if c.jwtConfig.KeyID == "" || c.jwtConfig.Issuer == "" {
+			errs = append(errs, errors.New("key ID and team ID are required"))
+		}
+
+		c.mtx.RLock()
+		issuedAt := c.tokenIssuedAt
+		c.mtx.RUnlock()
+		if skew := time.Since(issuedAt); skew < -maxClockSkew {
+			errs = append(errs, fmt.Errorf("provider token is issued %s in the future, check the clock", (-skew).Round(time.Second)))
+		}
+	}
+
+	if c.cert != nil {
+		leaf := c.cert.Leaf
+		if leaf == nil && len(c.cert.Certificate) > 0 {
+			var err error
+			if leaf, err = x509.ParseCertificate(c.cert.Certificate[0]); err != nil {
+				errs = append(errs, fmt.Errorf("invalid certificate: %w", err))
+			}
+		}
+		if leaf != nil && time.Now().After(leaf.NotAfter) {
+			errs = append(errs, fmt.Errorf("certificate expired at %s", leaf.NotAfter))
+		}
+	}
+
+	if (c.jwtConfig != nil || c.tokenManager != nil) && c.topic == "" {
+		errs = append(errs, errors.New("topic is required with token-based auth"))
+	}
+	return errors.Join(errs...)
+}
+
+// Send sends Notification to the APN service. If APNs rejects the provider token as expired, the token is renewed
+// and the notification is sent once again.
 func (c *Client) Send(ctx context.Context, deviceToken string, p Payload, opts ...SendOption) (*Response, error) {
-	req, err := c.newRequest(ctx, deviceToken, p, opts...)
+	if c.operationTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.operationTimeout)
+		defer cancel()
+	}
+	ctx, opts = fixExpiration(ctx, opts)
+
+	var (
+		resp *Response
+		err  error
+	)
+	if c.coalescer != nil {
+		resp, err = c.coalescer.submit(ctx, c.done, func(ctx context.Context) (*Response, error) {
+			return c.sendDeduplicated(ctx, deviceToken, p, opts...)
+		})
+	} else {
+		resp, err = c.sendDeduplicated(ctx, deviceToken, p, opts...)
+	}
+	if c.tokenLifecycleHandler != nil && (resp == nil || !resp.Deduplicated) {
+		if event, ok := tokenEventOf(err); ok {
+			token := deviceToken
+			if c.redactTokens {
+				token = HashToken(token)
+			}
+			go c.tokenLifecycleHandler(token, event)
+		}
+	}
+	return resp, err
+}
+
+// expiresAtKey is the context key of the expiration of the notification, see [fixExpiration].
+type expiresAtKey struct{}
+
+// fixExpiration evaluates the expiration of the notification once at the start of the send, so e.g. [WithTTL] is
+// not extended by retries. If the expiration is in the future, it is kept in the context, and the notification
+// is dropped, if it expires before an attempt to send it.
+func fixExpiration(ctx context.Context, opts []SendOption) (context.Context, []SendOption) {
+	h := make(http.Header)
+	for _, o := range opts {
+		o(h)
+	}
+	exp, err := strconv.ParseInt(h.Get("apns-expiration"), 10, 64)
+	if err != nil || exp <= time.Now().Unix() {
+		return ctx, opts
+	}
+	ctx = context.WithValue(ctx, expiresAtKey{}, time.Unix(exp, 0))
+	// Override the expiration of the options, that are applied to each attempt.
+	return ctx, append(opts[:len(opts):len(opts)], WithExpiration(int(exp)))
+}
+
+func (c *Client) sendDeduplicated(ctx context.Context, deviceToken string, p Payload, opts ...SendOption) (*Response, error) {
+	if c.dedup != nil {
+		h := make(http.Header)
+		for _, o := range opts {
+			o(h)
+		}
+		if id := h.Get("apns-id"); id != "" {
+			if !c.dedup.acquire(id) {
+				return &Response{NotificationID: id, Deduplicated: true}, nil
+			}
+			resp, err := c.sendRetrying(ctx, deviceToken, p, opts...)
+			if err != nil {
+				c.dedup.release(id)
+			}
+			return resp, err
+		}
+	}
+	return c.sendRetrying(ctx, deviceToken, p, opts...)
+}
+
+// SendNotification sends the Notification to the APN service. The send options are applied after the ID of
+// the notification.
+func (c *Client) SendNotification(ctx context.Context, n *Notification, opts ...SendOption) (*Response, error) {
+	if n.ID != (UUID{}) {
+		opts = append([]SendOption{WithNotificationID(n.ID.String())}, opts...)
+	}
+	return c.Send(ctx, n.DeviceToken, n.Payload, opts...)
+}
+
+func (c *Client) sendRenewingToken(ctx context.Context, deviceToken string, p Payload, opts ...SendOption) (*Response, error) {
+	resp, err := c.send(ctx, deviceToken, p, opts...)
+	if isUnprocessedGoAway(err) {
+		// APNs cycles connections with GOAWAY, the request is retried on a fresh connection.
+		resp, err = c.send(ctx, deviceToken, p, opts...)
+	}
+	if errors.Is(err, ErrExpiredProviderToken) && c.jwtConfig != nil {
+		if err := c.refreshToken(); err != nil {
+			return nil, err
+		}
+		return c.send(ctx, deviceToken, p, opts...)
+	}
+	return resp, err
+}
+
+func (c *Client) send(ctx context.Context, deviceToken string, p Payload, opts ...SendOption) (*Response, error) {
+	req, err := c.newRequest(ctx, []string{deviceToken}, p, opts...)
 	if err != nil {
 		return nil, err
 	}
-	return c.do(ctx, req)
+
+	if c.deadlineFromExpiration {
+		// There is no reason to wait for a notification, that is going to be discarded anyway.
+		exp, err := strconv.ParseInt(req.Header.Get("apns-expiration"), 10, 64)
+		if err == nil && exp > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithDeadline(ctx, time.Unix(exp, 0))
+			defer cancel()
+			req = req.WithContext(ctx)
+		}
+	}
+
+	resp, err := c.do(ctx, req)
+	c.stats.record(err)
+
+	var connErr *connError
+	if c.endpoints != nil && errors.As(err, &connErr) && connErr.err != nil && !isGoAway(err) {
+		// Fail over to another endpoint on a transport failure, the failed one is skipped for a while. APNs
+		// responses, e.g. ErrIdleTimeout, say nothing about the health of the endpoint.
+		if c.endpoints.markFailed(req.URL.String()) {
+			return c.send(ctx, deviceToken, p, opts...)
+		}
+	}
+	return resp, err
 }
 
-func (c *Client) newRequest(ctx context.Context, token string, p Payload, opts ...SendOption) (*http.Request, error) {
-	data, err := json.Marshal(p)
+func (c *Client) newRequest(ctx context.Context, deviceTokens []string, p Payload, opts ...SendOption) (*http.Request, error) {
+	if c.validatePayload {
+		if err := p.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := p.marshal(c.minOS)
 	if err != nil {
 		return nil, err
 	}
-	req, err := http.NewRequestWithContext(
-		ctx,
-		"POST",
-		fmt.Sprintf("%s/3/device/%s", c.endpoint, token),
-		bytes.NewBuffer(data),
-	)
+	path, body, err := c.encoder.Encode(deviceTokens, data)
+	if err != nil {
+		return nil, err
+	}
+	endpoint := c.endpoint
+	if c.endpoints != nil {
+		endpoint = c.endpoints.next()
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint+path, bytes.NewBuffer(body))
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
 
+	if c.jwtConfig != nil {
+		if c.lazyTokenRenewal > 0 {
+			err = c.refreshTokenIfOlder(c.lazyTokenRenewal)
+		} else {
+			// The first token is issued on the first send.
+			_, err = c.Token()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	c.mtx.RLock()
 	// If JWT is used, sendOpts sets `Authorization` header.
 	for _, o := range c.sendOpts {
@@ -95,67 +509,467 @@ func (c *Client) newRequest(ctx context.Context, token string, p Payload, opts .
 	}
 	c.mtx.RUnlock()
 
+	if c.tokenManager != nil {
+		token, err := c.tokenManager.Token(c.tokenTeamID)
+		if err != nil {
+			return nil, err
+		}
+		WithAuthorizationToken(token)(req.Header)
+	}
+
+	if c.collapseIDFunc != nil {
+		if id := c.collapseIDFunc(p); id != "" {
+			if len(id) > maxCollapseIDSize {
+				return nil, fmt.Errorf("%w: %d bytes", ErrBadCollapseID, len(id))
+			}
+			req.Header.Set("apns-collapse-id", id)
+		}
+	}
+
 	for _, o := range opts {
 		o(req.Header)
 	}
+
+	if expiresAt, ok := ctx.Value(expiresAtKey{}).(time.Time); ok && !time.Now().Before(expiresAt) {
+		return nil, ErrExpiredBeforeSend
+	}
+
+	if limit := maxPayloadSize(req.Header.Get("apns-push-type")); len(data) > limit {
+		return nil, fmt.Errorf("%w: %d bytes, the limit is %d", ErrPayloadTooLarge, len(data), limit)
+	}
+
+	switch v := req.Header.Get("apns-priority"); v {
+	case "", strconv.Itoa(PriorityLow), strconv.Itoa(PriorityNormal), strconv.Itoa(PriorityHigh):
+	default:
+		return nil, fmt.Errorf("%w: %s, it must be 1, 5 or 10", ErrBadPriority, v)
+	}
+
+	if v := req.Header.Get("apns-expiration"); v != "" {
+		if exp, err := strconv.ParseInt(v, 10, 64); err != nil || exp < 0 {
+			return nil, fmt.Errorf("%w: %s, it must be a UNIX epoch in seconds or 0", ErrBadExpirationDate, v)
+		}
+	}
+
+	if c.inferPushType && req.Header.Get("apns-push-type") == "" {
+		switch p.APS.interruptionLevel() {
+		case InterruptionLevelCritical, InterruptionLevelTimeSensitive:
+			req.Header.Set("apns-push-type", "alert")
+		}
+	}
+
+	if c.validatePayload {
+		if err := validateRequest(p, req.Header); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.topicValidator != nil {
+		if err := c.topicValidator(req.Header.Get("apns-topic")); err != nil {
+			return nil, err
+		}
+	}
 	return req, nil
 }
 
+// validateRequest checks the payload against the headers of the request.
+func validateRequest(p Payload, h http.Header) error {
+	if h.Get("apns-priority") == "10" && p.isBackgroundOnly() {
+		return fmt.Errorf("%w: priority 10 requires an alert, sound or badge", ErrBadPriority)
+	}
+	if t := h.Get("apns-push-type"); t != "" && t != "alert" && p.APS.FilterCriteria != "" {
+		return fmt.Errorf("filter-criteria is not supported by push type %s", t)
+	}
+	return nil
+}
+
 func (c *Client) do(ctx context.Context, req *http.Request) (*Response, error) {
-	resp, err := c.http.Do(req)
+	if timeout := c.timeoutOf(req); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(req.Context(), timeout)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
+
+	var tracer *connTracer
+	if c.connTrace {
+		ctx, tracer = withConnTrace(req.Context())
+		req = req.WithContext(ctx)
+	}
+
+	if c.rateLimiter != nil {
+		if err := c.rateLimiter.wait(req.Context()); err != nil {
+			return nil, err
+		}
+	}
+	c.stats.rate.mark()
+
+	if c.curlDump != nil {
+		c.dumpMtx.Lock()
+		// Failed dump is not a reason to fail the send.
+		_ = writeCurl(c.curlDump, req, c.unredactedDumps, c.redactTokens)
+		c.dumpMtx.Unlock()
+	}
+
+	start := time.Now()
+	resp, err := c.httpFor(req).Do(req)
 	if err != nil {
-		return nil, connError(err.Error())
+		if c.redactTokens {
+			err = redactError(err)
+		}
+		c.callRoundTripHook(req, nil, err, time.Since(start))
+		c.logRoundTrip(req, nil, nil, err)
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = ErrTimeout
+		} else {
+			err = &connError{err: err}
+		}
+		if tracer != nil {
+			// Keep diagnostics of the failed request, they are most valuable exactly in this case.
+			return &Response{ConnTrace: tracer.result(), Error: err}, err
+		}
+		return nil, err
 	}
 	defer resp.Body.Close()
 
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBodySize))
+	if err == nil {
+		// Drain the rest of an oversized body, so the connection can be reused.
+		_, _ = io.Copy(io.Discard, resp.Body)
+	}
+	if err != nil && c.redactTokens {
+		err = redactError(err)
+	}
+	c.callRoundTripHook(req, resp, err, time.Since(start))
+	if err != nil {
+		return nil, &connError{err: err}
+	}
+	c.logRoundTrip(req, resp, body, nil)
+
 	response := new(Response)
+	response.StatusCode = resp.StatusCode
 	response.NotificationID = resp.Header.Get("apns-id")
+	response.UniqueID = resp.Header.Get("apns-unique-id")
+	response.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	if c.captureResponseBody {
+		response.Body = body
+	}
+	if tracer != nil {
+		response.ConnTrace = tracer.result()
+	}
 
-	switch resp.StatusCode {
-	case http.StatusOK:
+	if c.isSuccess(resp.StatusCode) {
+		if len(body) > 0 {
+			// APNs normally responds with an empty body on success, but keep whatever it has sent.
+			_ = json.Unmarshal(body, response)
+		}
+		if c.requireResponseID && response.NotificationID == "" {
+			return response, ErrMissingResponseID
+		}
 		return response, nil
+	}
+
+	if c.errorDecoder != nil {
+		response.Error = c.errorDecoder(resp.StatusCode, body)
+		return response, response.Error
+	}
+
+	switch resp.StatusCode {
 	case http.StatusInternalServerError, http.StatusServiceUnavailable:
-		return nil, serverError(fmt.Sprintf("%d error: %s", resp.StatusCode, resp.Status))
+		response.Error = serverError(fmt.Sprintf("%d error: %s", resp.StatusCode, resp.Status))
+		return response, response.Error
 	default:
-		if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
-			return nil, err
+		if err := json.Unmarshal(body, response); err != nil || response.Error == nil {
+			// Malformed or truncated body, fall back to the error derived from the status code.
+			response.Body = body
+			response.Error = statusError(resp.StatusCode, resp.Status)
 		}
 		return response, response.Error
 	}
 }
 
-func (c *Client) renewToken(ctx context.Context, renewInterval time.Duration) {
-	tick := time.NewTicker(renewInterval)
+// logRoundTrip writes the round trip to the verbose log, if [WithVerboseLogging] is used.
+func (c *Client) logRoundTrip(req *http.Request, resp *http.Response, body []byte, err error) {
+	if c.verboseLog == nil {
+		return
+	}
+	c.dumpMtx.Lock()
+	defer c.dumpMtx.Unlock()
+	redactToken := c.redactTokens || !c.unredactedDumps
+	if err != nil && redactToken {
+		err = redactError(err)
+	}
+	// Failed logging is not a reason to fail the send.
+	_ = writeRoundTrip(c.verboseLog, req, resp, body, err, c.unredactedDumps, redactToken)
+}
+
+// callRoundTripHook calls the hook of [WithRoundTripHook], if any. The device token in the URL of the request is
+// redacted, if [WithTokenRedaction] is used.
+func (c *Client) callRoundTripHook(req *http.Request, resp *http.Response, err error, latency time.Duration) {
+	if c.roundTripHook == nil {
+		return
+	}
+	if c.redactTokens {
+		req = redactRequest(req)
+		if resp != nil {
+			redacted := *resp
+			redacted.Request = req
+			resp = &redacted
+		}
+	}
+	c.roundTripHook(req, resp, err, latency)
+}
+
+// isSuccess reports whether the status code means the notification is accepted, see [WithSuccessStatusCodes].
+func (c *Client) isSuccess(code int) bool {
+	if c.successCodes == nil {
+		return code == http.StatusOK
+	}
+	return c.successCodes[code]
+}
+
+// httpFor returns the HTTP client for the request: high-priority requests, i.e. voip or with priority 10, are sent
+// over the dedicated connection, if [WithPriorityIsolation] is used.
+func (c *Client) httpFor(req *http.Request) *http.Client {
+	if c.priorityHTTP != nil &&
+		(req.Header.Get("apns-priority") == "10" || req.Header.Get("apns-push-type") == "voip") {
+		return c.priorityHTTP
+	}
+	return c.http
+}
+
+// timeoutOf returns the timeout of the request: the one set for its priority by [WithPriorityTimeouts], the one
+// set by [WithRequestTimeout], or the default one, if the context has no deadline.
+func (c *Client) timeoutOf(req *http.Request) time.Duration {
+	if c.priorityTimeouts != nil {
+		priority, err := strconv.Atoi(req.Header.Get("apns-priority"))
+		if err != nil {
+			// APNs treats a notification without priority as priority 10.
+			priority = 10
+		}
+		if timeout, ok := c.priorityTimeouts[priority]; ok {
+			return timeout
+		}
+	}
+	if c.requestTimeout > 0 {
+		return c.requestTimeout
+	}
+	if _, ok := req.Context().Deadline(); !ok && !c.noDefaultTimeout {
+		return defaultRequestTimeout
+	}
+	return 0
+}
+
+func (c *Client) renewToken(ctx context.Context) {
+	timer := time.NewTimer(c.nextRenewal())
+	defer timer.Stop()
+
+	var failures int
 	for {
 		select {
-		case <-tick.C:
-			token, err := c.issueToken()
-			if err != nil {
-
+		case <-timer.C:
+			if wait := c.nextRenewal(); wait > 0 {
+				// The first token is not issued yet, or the token was issued after the timer was set.
+				timer.Reset(wait)
+				continue
 			}
-
-			c.mtx.Lock()
-			c.sendOpts["authorization"] = WithAuthorizationToken(token)
-			c.mtx.Unlock()
+			if err := c.refreshToken(); err != nil {
+				failures++
+				c.tokenRenewalFailed(err, failures)
+				timer.Reset(c.renewRetryWait(failures))
+				continue
+			}
+			failures = 0
+			timer.Reset(c.nextRenewal())
 		case <-ctx.Done():
 			return
+		case <-c.done:
+			return
 		}
 	}
 }
 
-func (c *Client) issueToken() (string, error) {
-	tNow := time.Now().UTC()
-	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.RegisteredClaims{
-		Issuer:    c.jwtConfig.Issuer,
-		IssuedAt:  jwt.NewNumericDate(tNow),
-		ExpiresAt: jwt.NewNumericDate(tNow.Add(defaultTokenValidityInterval)),
-	})
-	token.Header["kid"] = c.jwtConfig.KeyID
+// nextRenewal returns the wait before the next regular renewal: once the token is older than the interval set by
+// [WithTokenRenewInterval], or 90% of the token validity. Until the first token is issued on the first send,
+// the renewal is checked at the same interval.
+func (c *Client) nextRenewal() time.Duration {
+	renewAfter := c.tokenValidity * 9 / 10
+	if c.tokenRenewInterval > 0 {
+		renewAfter = c.tokenRenewInterval
+	}
+	c.mtx.RLock()
+	issuedAt := c.tokenIssuedAt
+	c.mtx.RUnlock()
+	if issuedAt.IsZero() {
+		return renewAfter
+	}
+	return time.Until(issuedAt.Add(renewAfter))
+}
+
+// tokenRenewalFailed reports the failed renewal, the previous token is used until a retry succeeds.
+func (c *Client) tokenRenewalFailed(err error, failures int) {
+	c.logger.Error("apns: failed to renew provider token", "key_id", c.KeyID(), "attempt", failures, "error", err)
+	if c.tokenErrorHandler != nil {
+		c.tokenErrorHandler(err)
+	}
+}
+
+// renewRetryWait returns the wait before the retry of a failed renewal. Without [WithTokenRenewBackoff] it is
+// retried on the next tick of [WithTokenRenewInterval], or after a minute.
+func (c *Client) renewRetryWait(failures int) time.Duration {
+	if c.tokenRenewBackoff != nil {
+		return c.tokenRenewBackoff.Next(failures)
+	}
+	if c.tokenRenewInterval > 0 {
+		return c.tokenRenewInterval
+	}
+	return defaultTokenRenewRetryInterval
+}
+
+func (c *Client) issueToken() (string, time.Time, error) {
+	return issueToken(c.jwtConfig, c.tokenValidity)
+}
+
+// refreshToken issues a new provider token and uses it for the following notifications.
+func (c *Client) refreshToken() error {
+	token, issuedAt, err := c.issueToken()
+	if err != nil {
+		return err
+	}
+
+	c.mtx.Lock()
+	c.setToken(token, issuedAt)
+	c.mtx.Unlock()
+	c.tokenRenewed(token, issuedAt)
+	return nil
+}
+
+// refreshTokenIfOlder refreshes the provider token if it is older than maxAge.
+func (c *Client) refreshTokenIfOlder(maxAge time.Duration) error {
+	c.mtx.RLock()
+	fresh := time.Since(c.tokenIssuedAt) < maxAge
+	c.mtx.RUnlock()
+	if fresh {
+		return nil
+	}
+
+	c.mtx.Lock()
+	// The token could have been refreshed by a concurrent send.
+	if time.Since(c.tokenIssuedAt) < maxAge {
+		c.mtx.Unlock()
+		return nil
+	}
+	token, issuedAt, err := c.issueToken()
+	if err != nil {
+		c.mtx.Unlock()
+		return err
+	}
+	c.setToken(token, issuedAt)
+	c.mtx.Unlock()
+	c.tokenRenewed(token, issuedAt)
+	return nil
+}
+
+// tokenRenewed records the issued provider token in the stats and the log, and calls the handler of
+// [WithTokenRenewedHandler]. Must be called without c.mtx held, as the handler may use the client.
+func (c *Client) tokenRenewed(token string, issuedAt time.Time) {
+	c.stats.renewals.Add(1)
+	c.logger.Info("apns: provider token is renewed", "key_id", c.KeyID(), "expires_at", issuedAt.Add(c.tokenValidity))
+
+	if c.tokenRenewedHandler != nil {
+		c.tokenRenewedHandler(token, issuedAt.Add(c.tokenValidity))
+	}
+}
+
+// setToken sets the provider token. Must be called with c.mtx held.
+func (c *Client) setToken(token string, issuedAt time.Time) {
+	c.sendOpts["authorization"] = WithAuthorizationToken(token)
+	c.token = token
+	c.tokenIssuedAt = issuedAt
+}
+
+// KeyID returns the key ID of the provider token, or an empty string, if JWT is not configured. It is safe to log.
+func (c *Client) KeyID() string {
+	if c.jwtConfig == nil {
+		return ""
+	}
+	return c.jwtConfig.KeyID
+}
+
+// TeamID returns the team ID, that issues the provider token, or an empty string, if JWT is not configured.
+// If [WithTokenManager] is used, it returns the team ID passed to it. It is safe to log.
+func (c *Client) TeamID() string {
+	if c.jwtConfig != nil {
+		return c.jwtConfig.Issuer
+	}
+	return c.tokenTeamID
+}
+
+// NextSafeRefresh returns the earliest time the provider token can be refreshed without risking
+// [ErrTooManyProviderTokenUpdates]: APNs throttles refreshes more often than once every 20 minutes. If that time
+// has already passed, it returns the current time. The zero time is returned, if no token is issued yet.
+func (c *Client) NextSafeRefresh() time.Time {
+	c.mtx.RLock()
+	issuedAt := c.tokenIssuedAt
+	c.mtx.RUnlock()
 
-	t, err := token.SignedString(c.jwtConfig.PrivateKey)
+	if issuedAt.IsZero() {
+		return time.Time{}
+	}
+	now := time.Now()
+	if next := issuedAt.Add(minTokenRefreshInterval); next.After(now) {
+		return next
+	}
+	return now
+}
+
+// Token returns the current provider token, e.g. to authorize requests to other APNs APIs with the same key.
+// The token is issued, if none is issued yet. If [WithTokenManager] is used, the token of its team is returned.
+func (c *Client) Token() (string, error) {
+	if c.tokenManager != nil {
+		return c.tokenManager.Token(c.tokenTeamID)
+	}
+	if c.jwtConfig == nil {
+		return "", errors.New("JWT is not configured")
+	}
+
+	c.mtx.RLock()
+	token := c.token
+	c.mtx.RUnlock()
+	if token != "" {
+		return token, nil
+	}
+
+	c.mtx.Lock()
+	// The token could have been issued by a concurrent call.
+	if c.token != "" {
+		token = c.token
+		c.mtx.Unlock()
+		return token, nil
+	}
+	token, issuedAt, err := c.issueToken()
 	if err != nil {
+		c.mtx.Unlock()
 		return "", err
 	}
+	c.setToken(token, issuedAt)
+	c.mtx.Unlock()
+	c.tokenRenewed(token, issuedAt)
+	return token, nil
+}
 
-	return t, nil
+// DecodeCurrentToken decodes the current provider token without verifying it, and returns its header and claims,
+// e.g. to check the `kid`, `iss`, `iat` and `exp` values when diagnosing authorization issues.
+// The signature is not exposed.
+func (c *Client) DecodeCurrentToken() (header map[string]any, claims map[string]any, err error) {
+	token, err := c.Token()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mapClaims := jwt.MapClaims{}
+	t, _, err := jwt.NewParser().ParseUnverified(token, mapClaims)
+	if err != nil {
+		return nil, nil, err
+	}
+	return t.Header, mapClaims, nil
 }
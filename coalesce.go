@@ -0,0 +1,107 @@
+package apns
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// coalescer collects sends for a short window, and dispatches them concurrently in batches.
+type coalescer struct {
+	window   time.Duration
+	maxBatch int
+	jobs     chan *coalesceJob
+}
+
+type coalesceJob struct {
+	ctx    context.Context
+	send   func(ctx context.Context) (*Response, error)
+	result chan coalesceResult
+}
+
+type coalesceResult struct {
+	resp *Response
+	err  error
+}
+
+func newCoalescer(window time.Duration, maxBatch int) *coalescer {
+	return &coalescer{
+		window:   window,
+		maxBatch: maxBatch,
+		jobs:     make(chan *coalesceJob),
+	}
+}
+
+// submit enqueues the send and waits for its result. If the coalescer is stopped, the send is done directly.
+func (q *coalescer) submit(ctx context.Context, done <-chan struct{}, send func(ctx context.Context) (*Response, error)) (*Response, error) {
+	job := &coalesceJob{
+		ctx:    ctx,
+		send:   send,
+		result: make(chan coalesceResult, 1),
+	}
+	select {
+	case q.jobs <- job:
+	case <-done:
+		return send(ctx)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	select {
+	case r := <-job.result:
+		return r.resp, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// run collects jobs until the window elapses or the batch is full, and then dispatches them. It returns, when
+// done is closed, dispatching the pending jobs and the ones being submitted. The dispatched sends are added to wg.
+func (q *coalescer) run(done <-chan struct{}, wg *sync.WaitGroup) {
+	var (
+		batch []*coalesceJob
+		timer *time.Timer
+		timeC <-chan time.Time
+	)
+	flush := func() {
+		wg.Add(len(batch))
+		for _, job := range batch {
+			go func(job *coalesceJob) {
+				defer wg.Done()
+				resp, err := job.send(job.ctx)
+				job.result <- coalesceResult{resp: resp, err: err}
+			}(job)
+		}
+		batch = nil
+		if timer != nil {
+			timer.Stop()
+			timeC = nil
+		}
+	}
+
+	for {
+		select {
+		case job := <-q.jobs:
+			batch = append(batch, job)
+			if len(batch) == 1 {
+				timer = time.NewTimer(q.window)
+				timeC = timer.C
+			}
+			if len(batch) >= q.maxBatch {
+				flush()
+			}
+		case <-timeC:
+			flush()
+		case <-done:
+			for {
+				select {
+				case job := <-q.jobs:
+					batch = append(batch, job)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package apns
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// dedupCache is an LRU of recently sent notification IDs. It holds at most size IDs, each for at most ttl.
+type dedupCache struct {
+	size int
+	ttl  time.Duration
+
+	mtx   sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type dedupEntry struct {
+	id     string
+	sentAt time.Time
+}
+
+func newDedupCache(size int, ttl time.Duration) *dedupCache {
+	return &dedupCache{
+		size:  size,
+		ttl:   ttl,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// acquire records the ID as sent and reports true, or reports false if the ID was sent within the window.
+func (d *dedupCache) acquire(id string) bool {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	now := time.Now()
+	if e, ok := d.items[id]; ok {
+		if now.Sub(e.Value.(*dedupEntry).sentAt) < d.ttl {
+			return false
+		}
+		d.ll.Remove(e)
+		delete(d.items, id)
+	}
+
+	d.items[id] = d.ll.PushFront(&dedupEntry{id: id, sentAt: now})
+	for d.ll.Len() > d.size {
+		e := d.ll.Back()
+		d.ll.Remove(e)
+		delete(d.items, e.Value.(*dedupEntry).id)
+	}
+	return true
+}
+
+// release forgets the ID, e.g. when its send failed and it should be possible to resend it.
+func (d *dedupCache) release(id string) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if e, ok := d.items[id]; ok {
+		d.ll.Remove(e)
+		delete(d.items, id)
+	}
+}
@@ -0,0 +1,27 @@
+package apns
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDedupCache(t *testing.T) {
+	d := newDedupCache(2, time.Minute)
+	assert.True(t, d.acquire("a"))
+	assert.False(t, d.acquire("a"))
+
+	d.release("a")
+	assert.True(t, d.acquire("a"))
+
+	// "a" is evicted as the least recently sent.
+	assert.True(t, d.acquire("b"))
+	assert.True(t, d.acquire("c"))
+	assert.True(t, d.acquire("a"))
+
+	d = newDedupCache(2, time.Nanosecond)
+	assert.True(t, d.acquire("a"))
+	time.Sleep(time.Millisecond)
+	assert.True(t, d.acquire("a"))
+}
@@ -0,0 +1,112 @@
+package apns
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// writeCurl writes the request as a `curl` command. The authorization header is redacted, unless includeAuth is set.
+func writeCurl(w io.Writer, req *http.Request, includeAuth bool, redactToken bool) error {
+	var body []byte
+	if req.GetBody != nil {
+		rc, err := req.GetBody()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		if body, err = io.ReadAll(rc); err != nil {
+			return err
+		}
+	}
+
+	keys := make([]string, 0, len(req.Header))
+	for k := range req.Header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString("curl --http2 -X " + req.Method)
+	for _, k := range keys {
+		for _, v := range req.Header[k] {
+			if k == "Authorization" && !includeAuth {
+				v = "bearer REDACTED"
+			}
+			fmt.Fprintf(&b, " -H %s", shellQuote(strings.ToLower(k)+": "+v))
+		}
+	}
+	if len(body) > 0 {
+		fmt.Fprintf(&b, " -d %s", shellQuote(string(body)))
+	}
+	u := req.URL.String()
+	if redactToken {
+		u = redactURL(u)
+	}
+	fmt.Fprintf(&b, " %s\n", shellQuote(u))
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// shellQuote quotes the string for POSIX shells.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// writeRoundTrip writes the request and the response, or the error of the request. The authorization header is
+// redacted, unless includeAuth is set.
+func writeRoundTrip(w io.Writer, req *http.Request, resp *http.Response, respBody []byte, err error, includeAuth bool, redactToken bool) error {
+	var b strings.Builder
+	u := req.URL.String()
+	if redactToken {
+		u = redactURL(u)
+	}
+	fmt.Fprintf(&b, "> %s %s\n", req.Method, u)
+	writeHeader(&b, "> ", req.Header, includeAuth)
+	b.WriteString(">\n")
+	if req.GetBody != nil {
+		rc, err := req.GetBody()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		body, err := io.ReadAll(rc)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&b, "> %s\n", body)
+	}
+
+	if err != nil {
+		fmt.Fprintf(&b, "< error: %v\n", err)
+	} else {
+		fmt.Fprintf(&b, "< %s\n", resp.Status)
+		writeHeader(&b, "< ", resp.Header, includeAuth)
+		b.WriteString("<\n")
+		if len(respBody) > 0 {
+			fmt.Fprintf(&b, "< %s\n", respBody)
+		}
+	}
+
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+func writeHeader(b *strings.Builder, prefix string, h http.Header, includeAuth bool) {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range h[k] {
+			if k == "Authorization" && !includeAuth {
+				v = "bearer REDACTED"
+			}
+			fmt.Fprintf(b, "%s%s: %s\n", prefix, strings.ToLower(k), v)
+		}
+	}
+}
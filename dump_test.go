@@ -0,0 +1,93 @@
+package apns
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithCurlDump(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	p := Payload{APS: APS{Alert: Alert{Body: "it's"}}}
+
+	var buf bytes.Buffer
+	c, err := New(
+		WithJWT(testPrivateKey, "key_id", "issuer"),
+		WithEndpoint(server.URL),
+		WithAppID("com.example.app"),
+		WithCurlDump(&buf),
+	)
+	assert.NoError(t, err)
+
+	_, err = c.Send(context.Background(), "test-token", p, WithPriority(5))
+	assert.NoError(t, err)
+	assert.Equal(t, "curl --http2 -X POST"+
+		" -H 'apns-priority: 5'"+
+		" -H 'apns-topic: com.example.app'"+
+		" -H 'authorization: bearer REDACTED'"+
+		" -H 'content-type: application/json'"+
+		` -d '{"aps":{"alert":{"body":"it'\''s"}}}'`+
+		" '"+server.URL+"/3/device/test-token'\n", buf.String())
+
+	buf.Reset()
+	c, err = New(
+		WithJWT(testPrivateKey, "key_id", "issuer"),
+		WithEndpoint(server.URL),
+		WithCurlDump(&buf),
+		WithUnredactedDumps(),
+		WithTokenRedaction(),
+	)
+	assert.NoError(t, err)
+
+	_, err = c.Send(context.Background(), "test-token", p)
+	assert.NoError(t, err)
+	assert.True(t, strings.Contains(buf.String(), "'authorization: bearer "+c.token+"'"))
+	assert.True(t, strings.HasSuffix(buf.String(), "/3/device/"+HashToken("test-token")+"'\n"))
+}
+
+func TestWithVerboseLogging(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("apns-id", "123e4567-e89b-12d3-a456-42665544000")
+		rw.WriteHeader(http.StatusBadRequest)
+		rw.Write([]byte(`{"reason":"BadDeviceToken"}`))
+	}))
+	defer server.Close()
+
+	var buf bytes.Buffer
+	c, err := New(
+		WithJWT(testPrivateKey, "key_id", "issuer"),
+		WithEndpoint(server.URL),
+		WithVerboseLogging(&buf),
+	)
+	assert.NoError(t, err)
+
+	_, err = c.Send(context.Background(), "test-token", Payload{APS: APS{Alert: Alert{Body: "hi"}}}, WithPriority(5))
+	assert.Equal(t, ErrBadDeviceToken, err)
+
+	log := buf.String()
+	assert.Contains(t, log, "> POST "+server.URL+"/3/device/"+HashToken("test-token")+"\n")
+	assert.Contains(t, log, "> apns-priority: 5\n")
+	assert.Contains(t, log, "> authorization: bearer REDACTED\n")
+	assert.Contains(t, log, `> {"aps":{"alert":{"body":"hi"}}}`+"\n")
+	assert.Contains(t, log, "< 400 Bad Request\n")
+	assert.Contains(t, log, "< apns-id: 123e4567-e89b-12d3-a456-42665544000\n")
+	assert.Contains(t, log, `< {"reason":"BadDeviceToken"}`+"\n")
+	assert.NotContains(t, log, "test-token")
+	assert.NotContains(t, log, c.token)
+
+	buf.Reset()
+	server.Close()
+	_, err = c.Send(context.Background(), "test-token", Payload{})
+	assert.Error(t, err)
+	assert.Contains(t, buf.String(), "< error: ")
+	assert.NotContains(t, buf.String(), "test-token")
+}
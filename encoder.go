@@ -0,0 +1,59 @@
+package apns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Encoder encodes requests on the wire. It allows to send notifications via gateways with a non-standard format,
+// e.g. relays, that accept multiple device tokens with one payload.
+type Encoder interface {
+	// Encode returns the path and the body of the request, that delivers the JSON payload to the device tokens.
+	Encode(deviceTokens []string, payload []byte) (path string, body []byte, err error)
+}
+
+// APNsEncoder is the standard encoder of APNs: one device token per request in the path, and the payload in the body.
+type APNsEncoder struct{}
+
+// Encode implements Encoder.
+func (APNsEncoder) Encode(deviceTokens []string, payload []byte) (string, []byte, error) {
+	if len(deviceTokens) != 1 {
+		return "", nil, errors.New("APNs accepts exactly one device token per request")
+	}
+	return fmt.Sprintf("/3/device/%s", deviceTokens[0]), payload, nil
+}
+
+// BatchEncoder encodes all device tokens into one request to Path with the body
+// `{"device_tokens": [...], "payload": {...}}`.
+type BatchEncoder struct {
+	Path string
+}
+
+// Encode implements Encoder.
+func (e BatchEncoder) Encode(deviceTokens []string, payload []byte) (string, []byte, error) {
+	if len(deviceTokens) == 0 {
+		return "", nil, errors.New("no device tokens")
+	}
+	body, err := json.Marshal(struct {
+		DeviceTokens []string        `json:"device_tokens"`
+		Payload      json.RawMessage `json:"payload"`
+	}{deviceTokens, payload})
+	if err != nil {
+		return "", nil, err
+	}
+	return e.Path, body, nil
+}
+
+// SendBatch sends the payload to all device tokens in one request, it requires an encoder, that supports multiple
+// device tokens, e.g. [BatchEncoder] set by [WithEncoder]. Unlike Send, it is not retried or deduplicated.
+func (c *Client) SendBatch(ctx context.Context, deviceTokens []string, p Payload, opts ...SendOption) (*Response, error) {
+	req, err := c.newRequest(ctx, deviceTokens, p, opts...)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(ctx, req)
+	c.stats.record(err)
+	return resp, err
+}
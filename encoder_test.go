@@ -0,0 +1,48 @@
+package apns
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendBatch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "/relay/batch", req.URL.Path)
+		body, err := io.ReadAll(req.Body)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"device_tokens":["a","b"],"payload":{"aps":{"alert":{"body":"hi"}}}}`, string(body))
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	p := Payload{APS: APS{Alert: Alert{Body: "hi"}}}
+
+	c, err := New(
+		WithJWT(testPrivateKey, "key_id", "issuer"),
+		WithEndpoint(server.URL),
+		WithEncoder(BatchEncoder{Path: "/relay/batch"}),
+	)
+	assert.NoError(t, err)
+
+	_, err = c.SendBatch(context.Background(), []string{"a", "b"}, p)
+	assert.NoError(t, err)
+	_, err = c.SendBatch(context.Background(), nil, p)
+	assert.Error(t, err)
+
+	c, err = New(WithJWT(testPrivateKey, "key_id", "issuer"), WithEndpoint(server.URL))
+	assert.NoError(t, err)
+	_, err = c.SendBatch(context.Background(), []string{"a", "b"}, p)
+	assert.EqualError(t, err, "APNs accepts exactly one device token per request")
+}
+
+func TestAPNsEncoder(t *testing.T) {
+	path, body, err := APNsEncoder{}.Encode([]string{"token"}, []byte(`{}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "/3/device/token", path)
+	assert.Equal(t, `{}`, string(body))
+}
@@ -0,0 +1,90 @@
+package apns
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// endpointCooldown is the time a failed endpoint is skipped for.
+const endpointCooldown = 30 * time.Second
+
+// endpointSelector picks endpoints by smooth weighted round-robin, skipping the failed ones.
+type endpointSelector struct {
+	mtx       sync.Mutex
+	endpoints []*weightedEndpoint
+}
+
+type weightedEndpoint struct {
+	url       string
+	weight    int
+	current   int
+	downUntil time.Time
+}
+
+func newEndpointSelector(weights map[string]int) (*endpointSelector, error) {
+	if len(weights) == 0 {
+		return nil, errors.New("no endpoints")
+	}
+	s := &endpointSelector{}
+	for url, weight := range weights {
+		if weight <= 0 {
+			return nil, errors.New("endpoint weight must be positive")
+		}
+		s.endpoints = append(s.endpoints, &weightedEndpoint{url: url, weight: weight})
+	}
+	sort.Slice(s.endpoints, func(i, j int) bool {
+		return s.endpoints[i].url < s.endpoints[j].url
+	})
+	return s, nil
+}
+
+// next returns the endpoint for the next request. If all endpoints are failed, they are all used.
+func (s *endpointSelector) next() string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	now := time.Now()
+	candidates := make([]*weightedEndpoint, 0, len(s.endpoints))
+	for _, e := range s.endpoints {
+		if now.After(e.downUntil) {
+			candidates = append(candidates, e)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = s.endpoints
+	}
+
+	var (
+		best  *weightedEndpoint
+		total int
+	)
+	for _, e := range candidates {
+		e.current += e.weight
+		total += e.weight
+		if best == nil || e.current > best.current {
+			best = e
+		}
+	}
+	best.current -= total
+	return best.url
+}
+
+// markFailed skips the endpoint of the URL for a while, and reports whether other endpoints are available.
+func (s *endpointSelector) markFailed(url string) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	now := time.Now()
+	available := false
+	for _, e := range s.endpoints {
+		if strings.HasPrefix(url, strings.TrimSuffix(e.url, "/")+"/") {
+			e.downUntil = now.Add(endpointCooldown)
+		} else if now.After(e.downUntil) {
+			available = true
+		}
+	}
+	return available
+}
@@ -0,0 +1,90 @@
+package apns
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEndpointSelector(t *testing.T) {
+	s, err := newEndpointSelector(map[string]int{"https://a": 3, "https://b": 1})
+	assert.NoError(t, err)
+
+	picks := map[string]int{}
+	for i := 0; i < 8; i++ {
+		picks[s.next()]++
+	}
+	assert.Equal(t, map[string]int{"https://a": 6, "https://b": 2}, picks)
+
+	assert.True(t, s.markFailed("https://a/3/device/token"))
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, "https://b", s.next())
+	}
+
+	// All endpoints are failed, they are all used again.
+	assert.False(t, s.markFailed("https://b/3/device/token"))
+	picks = map[string]int{}
+	for i := 0; i < 4; i++ {
+		picks[s.next()]++
+	}
+	assert.Len(t, picks, 2)
+
+	_, err = newEndpointSelector(nil)
+	assert.Error(t, err)
+	_, err = newEndpointSelector(map[string]int{"https://a": 0})
+	assert.Error(t, err)
+}
+
+func TestWithEndpointsFailover(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	down := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+	down.Close()
+
+	c, err := New(
+		WithJWT(testPrivateKey, "key_id", "issuer"),
+		WithEndpoints(map[string]int{server.URL: 1, down.URL: 100}),
+	)
+	assert.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		_, err = c.Send(context.Background(), "test-token", Payload{})
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, uint64(1), c.Stats().ConnErrors)
+}
+
+func TestWithEndpointsNoFailoverOnIdleTimeout(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	idle := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusBadRequest)
+		rw.Write([]byte(`{"reason": "IdleTimeout"}`))
+	}))
+	defer idle.Close()
+
+	c, err := New(
+		WithJWT(testPrivateKey, "key_id", "issuer"),
+		WithEndpoints(map[string]int{server.URL: 1, idle.URL: 100}),
+	)
+	assert.NoError(t, err)
+
+	_, err = c.Send(context.Background(), "test-token", Payload{})
+	assert.Equal(t, err, ErrIdleTimeout)
+	assert.Equal(t, atomic.LoadInt32(&requests), int32(0))
+	for _, e := range c.endpoints.endpoints {
+		assert.True(t, e.downUntil.IsZero(), e.url)
+	}
+}
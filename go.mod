@@ -1,13 +1,16 @@
 module github.com/edganiukov/apns
 
-go 1.20
+go 1.21
 
 require (
 	github.com/golang-jwt/jwt/v4 v4.4.3
 	github.com/stretchr/testify v1.3.0
+	golang.org/x/crypto v0.31.0
+	golang.org/x/net v0.33.0
 )
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
+	golang.org/x/text v0.21.0 // indirect
 )
@@ -1,22 +1,201 @@
 package apns
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// Notification is a notification to a single device.
+type Notification struct {
+	// ID identifies the notification, it is sent as `apns-id` header. If it is zero, APNs generates one.
+	ID          UUID
+	DeviceToken string
+	Payload     Payload
+}
+
+// NewNotification creates new Notification with a random ID.
+func NewNotification(deviceToken string, p Payload) *Notification {
+	return &Notification{
+		ID:          NewUUID(),
+		DeviceToken: deviceToken,
+		Payload:     p,
+	}
+}
 
 // Payload repsresents a data structure for APN notification.
 type Payload struct {
-	APS          APS
+	APS APS
+	// RawAPS, if set, is sent verbatim as the aps dictionary instead of APS, e.g. to use keys the library does not
+	// model yet. It must be a JSON object.
+	RawAPS       json.RawMessage
 	CustomValues map[string]any
+	// StringAlert sends an alert, that has only the body, as a string, e.g. `"alert": "Hello"`, instead of
+	// the dictionary. The dictionary is still sent, if any other alert field is set.
+	StringAlert bool
 }
 
 // MarshalJSON converts Payload structure to the byte array.
 // Implements json.Marshaler interface.
 func (p Payload) MarshalJSON() ([]byte, error) {
-	if p.CustomValues == nil {
-		p.CustomValues = make(map[string]any)
+	values, err := p.customValues()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.RawAPS != nil {
+		if !isJSONObject(p.RawAPS) {
+			return nil, errors.New("raw aps must be a JSON object")
+		}
+		values["aps"] = p.RawAPS
+	} else {
+		p.APS.stringAlert = p.StringAlert
+		values["aps"] = p.APS
+	}
+	return json.Marshal(values)
+}
+
+// errReservedAPSKey is returned, if the custom values shadow the aps dictionary.
+var errReservedAPSKey = errors.New("custom values must not contain the reserved aps key")
+
+// customValues returns a copy of the custom values, the aps dictionary is added to. The copy keeps the map of
+// the caller intact.
+func (p Payload) customValues() (map[string]any, error) {
+	if _, ok := p.CustomValues["aps"]; ok {
+		return nil, errReservedAPSKey
+	}
+	values := make(map[string]any, len(p.CustomValues)+1)
+	for k, v := range p.CustomValues {
+		values[k] = v
+	}
+	return values, nil
+}
+
+// isJSONObject reports whether the data is a valid JSON object.
+func isJSONObject(data []byte) bool {
+	var v map[string]json.RawMessage
+	return json.Unmarshal(data, &v) == nil && v != nil
+}
+
+// Size returns the size of the payload in bytes, as it is sent to APNs.
+func (p Payload) Size() (int, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// Maximum payload sizes, that APNs accepts.
+const (
+	maxPayloadSizeDefault = 4096
+	maxPayloadSizeVoIP    = 5120
+)
+
+// maxPayloadSize returns the maximum payload size of the push type.
+func maxPayloadSize(pushType string) int {
+	if pushType == "voip" {
+		return maxPayloadSizeVoIP
+	}
+	return maxPayloadSizeDefault
+}
+
+// SimulatorJSON returns the payload in the format of `.apns` files, that can be dragged onto an iOS simulator or
+// passed to `xcrun simctl push`, to test notifications without a device. The bundleID is the bundle ID
+// of the target app.
+func (p Payload) SimulatorJSON(bundleID string) ([]byte, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	if fields["Simulator Target Bundle"], err = json.Marshal(bundleID); err != nil {
+		return nil, err
+	}
+	return json.Marshal(fields)
+}
+
+// Validate checks the payload for mistakes, that APNs rejects or ignores, and returns all found problems
+// joined into a single error. The conflicts with the send options, e.g. priority 10 for a background notification,
+// are checked by [WithPayloadValidation].
+func (p Payload) Validate() error {
+	if p.isEmpty() {
+		return ErrPayloadEmpty
+	}
+
+	var errs []error
+	if _, ok := p.CustomValues["aps"]; ok {
+		errs = append(errs, errReservedAPSKey)
+	}
+	if p.APS.Badge != nil && *p.APS.Badge < 0 {
+		errs = append(errs, errors.New("badge must not be negative"))
+	}
+	if p.APS.Category != "" && strings.TrimSpace(p.APS.Category) == "" {
+		errs = append(errs, errors.New("category must not be blank"))
+	}
+	if v := p.APS.RelevanceScore; v != nil && (*v < 0 || *v > 1) {
+		errs = append(errs, errors.New("relevance-score must be between 0 and 1"))
+	}
+	if v := p.APS.ContentAvailable; v != nil && *v != 0 && *v != 1 {
+		errs = append(errs, errors.New("content-available must be 0 or 1"))
+	}
+	if v := p.APS.MutableContent; v != nil && *v != 0 && *v != 1 {
+		errs = append(errs, errors.New("mutable-content must be 0 or 1"))
 	}
+	if v := p.APS.MutableContent; v != nil && *v == 1 && p.APS.Alert.isEmpty() {
+		errs = append(errs, errors.New("mutable-content requires an alert to trigger the notification service extension"))
+	}
+	if p.APS.InterruptionLevel != "" && p.APS.InteraptionLevel != "" {
+		errs = append(errs, errInterruptionLevelTwice)
+	}
+	switch p.APS.interruptionLevel() {
+	case "", InterruptionLevelPassive, InterruptionLevelActive, InterruptionLevelTimeSensitive, InterruptionLevelCritical:
+	default:
+		errs = append(errs, errors.New("interruption-level must be passive, active, time-sensitive or critical"))
+	}
+	if s := p.APS.CriticalSound; s != nil {
+		if s.Name == "" {
+			errs = append(errs, errors.New("critical sound requires a name"))
+		}
+		if s.Volume != nil && (*s.Volume < 0 || *s.Volume > 1) {
+			errs = append(errs, errors.New("critical sound volume must be between 0 and 1"))
+		}
+	}
+	if p.APS.FilterCriteria != "" {
+		if strings.TrimSpace(p.APS.FilterCriteria) == "" {
+			errs = append(errs, errors.New("filter-criteria must not be blank"))
+		}
+		if p.APS.Alert.isEmpty() {
+			errs = append(errs, errors.New("filter-criteria requires an alert"))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// isEmpty reports whether the payload has nothing to deliver: the marshaled aps dictionary has no keys and there
+// are no custom values. So e.g. a Live Activity update or a payload with only thread-id is not empty.
+func (p Payload) isEmpty() bool {
+	if p.RawAPS != nil || len(p.CustomValues) > 0 {
+		return false
+	}
+	data, err := json.Marshal(p.APS)
+	if err != nil {
+		// Not empty, the error is reported by the other checks.
+		return false
+	}
+	var keys map[string]json.RawMessage
+	return json.Unmarshal(data, &keys) == nil && len(keys) == 0
+}
 
-	p.CustomValues["aps"] = p.APS
-	return json.Marshal(p.CustomValues)
+// isBackgroundOnly reports whether the payload is a background notification: it has content-available and no alert,
+// sound or badge.
+func (p Payload) isBackgroundOnly() bool {
+	return p.APS.ContentAvailable != nil && *p.APS.ContentAvailable == 1 &&
+		p.APS.Alert.isEmpty() && !p.APS.hasSound() && p.APS.Badge == nil
 }
 
 // APS is Apple's reserved payload.
@@ -30,32 +209,42 @@ type APS struct {
 	// Sound is the name of a sound file to play as an alert.
 	Sound string `json:"sound,omitempty"`
 
+	// CriticalSound is the sound of a critical alert, it is sent instead of Sound.
+	CriticalSound *CriticalSound `json:"-"`
+
 	// ThreadID presents the app-specific identifier for grouping notifications.
 	ThreadID string `json:"thread-id,omitempty"`
 
 	// Category identifier for custom actions in iOS 8 or newer.
 	Category string `json:"category,omitempty"`
 
-	// Content available apps launched in the background or resumed.
+	// Content available apps launched in the background or resumed. A background notification sets it to 1 and has
+	// no alert, sound or badge.
 	ContentAvailable *int `json:"content-available,omitempty"`
 
 	// The notification service app extension flag. If the value is 1, the system passes the notification to your
-	// notification service app extension before delivery.
+	// notification service app extension before delivery. The extension only runs for notifications with an alert,
+	// it can be combined with content-available to also wake up the app in the background.
 	MutableContent *int `json:"mutable-content,omitempty"`
 
 	// The identifier of the window brought forward. The value of this key will be populated on the
 	// UN NotificationContent object created from the push payload.
 	TargetContentID string `json:"target-content-id,omitempty"`
 
-	// The importance and delivery timing of a notification. The string values “passive”, “active”, “time-sensitive”,
-	// or “critical” correspond to the UNNotificationInterruptionLevel enumeration cases.
-	InteraptionLevel string `json:"interruption-level,omitempty"`
+	// The importance and delivery timing of a notification.
+	InterruptionLevel InterruptionLevel `json:"interruption-level,omitempty"`
+
+	// Deprecated: Use InterruptionLevel. InteraptionLevel is sent, if InterruptionLevel is empty, setting both is
+	// an error.
+	InteraptionLevel string `json:"-"`
 
 	// The relevance score, a number between 0 and 1, that the system uses to sort the notifications from your app.
 	// The highest score gets featured in the notification summary.
-	RelevanceScore *int `json:"relevance-score,omitempty"`
+	RelevanceScore *float64 `json:"relevance-score,omitempty"`
 
-	// The criteria the system evaluates to determine if it displays the notification in the current Focus.
+	// The criteria the system evaluates to determine if it displays the notification in the current Focus. The value
+	// is the identifier, that the app's Focus filter returns from its `filterPredicate`, e.g. "work". It is only
+	// meaningful for alert notifications on iOS 16 or newer.
 	FilterCriteria string `json:"filter-criteria,omitempty"`
 
 	// The UNIX timestamp that represents the date at which a Live Activity becomes stale, or out of date.
@@ -63,7 +252,10 @@ type APS struct {
 
 	// The updated or final content for a Live Activity. The content of this dictionary must match the data you
 	// describe with your custom ActivityAttributes implementation.
-	ContentStale map[string]string `json:"content-state,omitempty"`
+	ContentState map[string]any `json:"content-state,omitempty"`
+
+	// Deprecated: Use ContentState, that allows non-string values. ContentStale is sent, if ContentState is nil.
+	ContentStale map[string]string `json:"-"`
 
 	// The UNIX timestamp that marks the time when you send the remote notification that updates or ends a Live
 	// Activity.
@@ -71,7 +263,95 @@ type APS struct {
 
 	// The string that describes whether you update or end an ongoing Live Activity with the remote push notification.
 	// To update the Live Activity, use update. To end the Live Activity, use end.
-	Events string `json:"events,omitempty"`
+	Events string `json:"event,omitempty"`
+
+	// stringAlert is set from Payload.StringAlert.
+	stringAlert bool
+}
+
+// errInterruptionLevelTwice is returned, if both the interruption level and its deprecated misspelled alias are set.
+var errInterruptionLevelTwice = errors.New("InteraptionLevel is deprecated and must not be set with InterruptionLevel")
+
+// interruptionLevel returns the interruption level, that is sent, falling back to the deprecated InteraptionLevel.
+func (a APS) interruptionLevel() InterruptionLevel {
+	if a.InterruptionLevel == "" {
+		return InterruptionLevel(a.InteraptionLevel)
+	}
+	return a.InterruptionLevel
+}
+
+func (a APS) hasSound() bool {
+	return a.Sound != "" || a.CriticalSound != nil
+}
+
+// MarshalJSON implements json.Marshaler, it omits the empty alert, so background notifications have no alert key.
+// A body-only alert is sent as a string, if Payload.StringAlert is set.
+func (a APS) MarshalJSON() ([]byte, error) {
+	if a.InterruptionLevel != "" && a.InteraptionLevel != "" {
+		return nil, errInterruptionLevelTwice
+	}
+
+	type aps APS
+	v := struct {
+		Alert any `json:"alert,omitempty"`
+		Sound any `json:"sound,omitempty"`
+		aps
+	}{aps: aps(a)}
+	if a.CriticalSound != nil {
+		v.Sound = a.CriticalSound
+	} else if a.Sound != "" {
+		v.Sound = a.Sound
+	}
+	switch {
+	case a.stringAlert && a.Alert.isBodyOnly():
+		v.Alert = a.Alert.Body
+	case !a.Alert.isEmpty():
+		v.Alert = &a.Alert
+	}
+	v.InterruptionLevel = a.interruptionLevel()
+	if a.ContentState == nil && a.ContentStale != nil {
+		v.ContentState = make(map[string]any, len(a.ContentStale))
+		for k, s := range a.ContentStale {
+			v.ContentState[k] = s
+		}
+	}
+	return json.Marshal(v)
+}
+
+// InterruptionLevel is the importance and delivery timing of a notification, it corresponds to
+// the UNNotificationInterruptionLevel enumeration cases.
+type InterruptionLevel string
+
+// Possible interruption levels.
+const (
+	// InterruptionLevelPassive adds the notification to the notification list without lighting up the screen or
+	// playing a sound.
+	InterruptionLevelPassive InterruptionLevel = "passive"
+	// InterruptionLevelActive presents the notification immediately, lights up the screen, and can play a sound.
+	InterruptionLevelActive InterruptionLevel = "active"
+	// InterruptionLevelTimeSensitive presents the notification immediately, and can break through Focus.
+	InterruptionLevelTimeSensitive InterruptionLevel = "time-sensitive"
+	// InterruptionLevelCritical presents the notification immediately, and bypasses the mute switch. It requires
+	// the critical alerts entitlement.
+	InterruptionLevelCritical InterruptionLevel = "critical"
+)
+
+// CriticalSound is the sound of a critical alert, that plays even if the device is muted or in Do Not Disturb mode.
+// The app requires the critical alerts entitlement.
+type CriticalSound struct {
+	// Name is the name of a sound file in the app bundle, or "default" for the system sound.
+	Name string
+	// Volume is the volume of the sound between 0 and 1. If nil, the full volume is used.
+	Volume *float64
+}
+
+// MarshalJSON implements json.Marshaler.
+func (s CriticalSound) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Critical int      `json:"critical"`
+		Name     string   `json:"name"`
+		Volume   *float64 `json:"volume,omitempty"`
+	}{1, s.Name, s.Volume})
 }
 
 // Alert represents aler dictionary.
@@ -109,6 +389,20 @@ type Alert struct {
 	LocArgs []string `json:"loc-args,omitempty"`
 }
 
+// isBodyOnly reports whether the alert has the body and no other fields.
+func (a Alert) isBodyOnly() bool {
+	body := a.Body
+	a.Body = ""
+	return body != "" && a.isEmpty()
+}
+
+func (a Alert) isEmpty() bool {
+	return a.Title == "" && a.Subtitle == "" && a.Body == "" && a.LaunchImage == "" &&
+		a.TitleLocKey == "" && len(a.TitleLocArgs) == 0 &&
+		a.SubtitleLocKey == "" && len(a.SubtitleLocArgs) == 0 &&
+		a.LocKey == "" && len(a.LocArgs) == 0
+}
+
 // Pointer returns a pointer to a provided value.
 func Pointer[T any](v T) *T {
 	return &v
@@ -0,0 +1,123 @@
+package apns
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files")
+
+func TestPayloadMarshalGolden(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		p    Payload
+	}{
+		{
+			name: "alert",
+			p: Payload{
+				APS: APS{
+					Alert: Alert{
+						Title:        "Title",
+						Subtitle:     "Subtitle",
+						Body:         "Body",
+						LaunchImage:  "launch.png",
+						TitleLocKey:  "TITLE",
+						TitleLocArgs: []string{"a"},
+						LocKey:       "BODY",
+						LocArgs:      []string{"b", "c"},
+					},
+					Badge:             Pointer(3),
+					Sound:             "default",
+					ThreadID:          "thread",
+					Category:          "category",
+					MutableContent:    Pointer(1),
+					TargetContentID:   "target",
+					InterruptionLevel: InterruptionLevelTimeSensitive,
+					RelevanceScore:    Pointer(0.75),
+					FilterCriteria:    "work",
+				},
+				CustomValues: map[string]any{"key": "value", "number": 1},
+			},
+		},
+		{
+			name: "background",
+			p: Payload{
+				APS:          APS{ContentAvailable: Pointer(1)},
+				CustomValues: map[string]any{"sync": true},
+			},
+		},
+		{
+			name: "live_activity",
+			p: Payload{
+				APS: APS{
+					Alert:        Alert{Title: "Score", Body: "Goal!"},
+					Timestamp:    Pointer(1700000000),
+					Events:       "update",
+					StaleDate:    Pointer(1700003600),
+					ContentState: map[string]any{"home": 1, "away": 0, "period": "2nd"},
+				},
+			},
+		},
+		{
+			name: "deprecated_interaption_level",
+			p: Payload{
+				APS: APS{
+					Alert:            Alert{Body: "Meeting starts"},
+					InteraptionLevel: "time-sensitive",
+				},
+			},
+		},
+		{
+			name: "live_activity_deprecated_content_stale",
+			p: Payload{
+				APS: APS{
+					Timestamp:    Pointer(1700000000),
+					Events:       "end",
+					ContentStale: map[string]string{"status": "final"},
+				},
+			},
+		},
+		{
+			name: "string_alert",
+			p: Payload{
+				APS:         APS{Alert: Alert{Body: "Hello"}, Sound: "default"},
+				StringAlert: true,
+			},
+		},
+		{
+			name: "string_alert_with_title",
+			p: Payload{
+				APS:         APS{Alert: Alert{Title: "Hi", Body: "Hello"}},
+				StringAlert: true,
+			},
+		},
+		{
+			name: "critical",
+			p: Payload{
+				APS: APS{
+					Alert:             Alert{Body: "Fire alarm"},
+					CriticalSound:     &CriticalSound{Name: "alarm.caf", Volume: Pointer(1.0)},
+					InterruptionLevel: InterruptionLevelCritical,
+				},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := json.Marshal(tc.p)
+			assert.NoError(t, err)
+
+			path := filepath.Join("testdata", tc.name+".golden")
+			if *updateGolden {
+				assert.NoError(t, os.WriteFile(path, data, 0o644))
+			}
+			want, err := os.ReadFile(path)
+			assert.NoError(t, err)
+			assert.Equal(t, string(want), string(data))
+		})
+	}
+}
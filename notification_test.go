@@ -0,0 +1,226 @@
+package apns
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPayloadValidate(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		p := Payload{
+			APS: APS{
+				Alert: Alert{Body: "hi"},
+				Badge: Pointer(0),
+			},
+		}
+		assert.NoError(t, p.Validate())
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		assert.Equal(t, Payload{}.Validate(), ErrPayloadEmpty)
+		assert.NoError(t, Payload{APS: APS{ContentAvailable: Pointer(1)}}.Validate())
+		assert.NoError(t, Payload{CustomValues: map[string]any{"key": "value"}}.Validate())
+		assert.NoError(t, Payload{APS: APS{ThreadID: "thread"}}.Validate())
+		assert.NoError(t, Payload{APS: APS{
+			Timestamp:    Pointer(1700000000),
+			Events:       "update",
+			ContentState: map[string]any{"score": 1},
+		}}.Validate())
+		assert.Equal(t, Payload{APS: APS{Alert: Alert{}, ContentStale: map[string]string{}}}.Validate(), ErrPayloadEmpty)
+	})
+
+	t.Run("negative badge", func(t *testing.T) {
+		p := Payload{
+			APS: APS{
+				Alert: Alert{Body: "hi"},
+				Badge: Pointer(-1),
+			},
+		}
+		assert.EqualError(t, p.Validate(), "badge must not be negative")
+	})
+
+	t.Run("reserved aps key", func(t *testing.T) {
+		p := Payload{
+			APS: APS{
+				Alert: Alert{Body: "hi"},
+				Badge: Pointer(-1),
+			},
+			CustomValues: map[string]any{"aps": map[string]any{"badge": 1}},
+		}
+		assert.EqualError(t, p.Validate(), "custom values must not contain the reserved aps key\nbadge must not be negative")
+	})
+
+	t.Run("relevance score out of range", func(t *testing.T) {
+		p := Payload{
+			APS: APS{
+				Alert:          Alert{Body: "hi"},
+				RelevanceScore: Pointer(1.5),
+			},
+		}
+		assert.EqualError(t, p.Validate(), "relevance-score must be between 0 and 1")
+	})
+
+	t.Run("mutable content without alert", func(t *testing.T) {
+		p := Payload{
+			APS: APS{
+				MutableContent:   Pointer(1),
+				ContentAvailable: Pointer(1),
+			},
+		}
+		assert.EqualError(t, p.Validate(),
+			"mutable-content requires an alert to trigger the notification service extension")
+	})
+
+	t.Run("invalid flags", func(t *testing.T) {
+		p := Payload{
+			APS: APS{
+				Alert:            Alert{Body: "hi"},
+				MutableContent:   Pointer(2),
+				ContentAvailable: Pointer(2),
+			},
+		}
+		assert.EqualError(t, p.Validate(), "content-available must be 0 or 1\nmutable-content must be 0 or 1")
+	})
+
+	t.Run("interruption level", func(t *testing.T) {
+		p := Payload{APS: APS{Alert: Alert{Body: "hi"}, InterruptionLevel: InterruptionLevelPassive}}
+		assert.NoError(t, p.Validate())
+
+		p.APS.InteraptionLevel = "urgent"
+		assert.EqualError(t, p.Validate(), "InteraptionLevel is deprecated and must not be set with InterruptionLevel")
+
+		p.APS.InterruptionLevel = ""
+		assert.EqualError(t, p.Validate(), "interruption-level must be passive, active, time-sensitive or critical")
+	})
+
+	t.Run("critical sound", func(t *testing.T) {
+		assert.NoError(t, Payload{APS: APS{CriticalSound: &CriticalSound{Name: "default"}}}.Validate())
+
+		p := Payload{
+			APS: APS{
+				CriticalSound: &CriticalSound{Volume: Pointer(1.5)},
+			},
+		}
+		assert.EqualError(t, p.Validate(), "critical sound requires a name\ncritical sound volume must be between 0 and 1")
+	})
+
+	t.Run("filter criteria", func(t *testing.T) {
+		p := Payload{
+			APS: APS{
+				Alert:          Alert{Body: "hi"},
+				FilterCriteria: "work",
+			},
+		}
+		assert.NoError(t, p.Validate())
+
+		p = Payload{
+			APS: APS{
+				ContentAvailable: Pointer(1),
+				FilterCriteria:   " ",
+			},
+		}
+		assert.EqualError(t, p.Validate(), "filter-criteria must not be blank\nfilter-criteria requires an alert")
+	})
+}
+
+func TestPayloadSimulatorJSON(t *testing.T) {
+	p := Payload{
+		APS: APS{
+			Alert: Alert{Body: "hi"},
+		},
+		CustomValues: map[string]any{"key": "value"},
+	}
+	data, err := p.SimulatorJSON("com.example.app")
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"Simulator Target Bundle": "com.example.app",
+		"aps": {"alert": {"body": "hi"}},
+		"key": "value"
+	}`, string(data))
+}
+
+func TestPayloadRawAPS(t *testing.T) {
+	p := Payload{
+		APS:          APS{Alert: Alert{Body: "ignored"}},
+		RawAPS:       json.RawMessage(`{"alert":"hi","new-key":1}`),
+		CustomValues: map[string]any{"key": "value"},
+	}
+	data, err := json.Marshal(p)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"aps":{"alert":"hi","new-key":1},"key":"value"}`, string(data))
+	assert.NoError(t, p.Validate())
+
+	for _, raw := range []string{`[1]`, `"aps"`, `null`, `{"a":`} {
+		_, err = json.Marshal(Payload{RawAPS: json.RawMessage(raw)})
+		assert.Error(t, err, raw)
+	}
+}
+
+func TestPayloadMarshalJSONCustomValues(t *testing.T) {
+	p := Payload{
+		APS:          APS{Alert: Alert{Body: "hi"}},
+		CustomValues: map[string]any{"key": "value"},
+	}
+	_, err := json.Marshal(p)
+	assert.NoError(t, err)
+	// The map of the caller is not changed.
+	assert.Equal(t, map[string]any{"key": "value"}, p.CustomValues)
+
+	p.CustomValues["aps"] = map[string]any{"alert": "custom"}
+	_, err = json.Marshal(p)
+	assert.True(t, errors.Is(err, errReservedAPSKey))
+	_, err = p.marshal(osVersion{major: 15})
+	assert.Equal(t, errReservedAPSKey, err)
+}
+
+func TestPayloadMarshalJSONConcurrent(t *testing.T) {
+	p := Payload{
+		APS:          APS{Alert: Alert{Body: "hi"}},
+		CustomValues: map[string]any{"key": "value"},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, err := json.Marshal(p)
+			assert.NoError(t, err)
+			assert.JSONEq(t, `{"aps":{"alert":{"body":"hi"}},"key":"value"}`, string(data))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, map[string]any{"key": "value"}, p.CustomValues)
+}
+
+func TestPayloadRelevanceScore(t *testing.T) {
+	for score, want := range map[float64]string{
+		0.75: `{"aps":{"alert":"hi","relevance-score":0.75}}`,
+		// Zero is sent, as it is set.
+		0: `{"aps":{"alert":"hi","relevance-score":0}}`,
+	} {
+		p := Payload{
+			APS:         APS{Alert: Alert{Body: "hi"}, RelevanceScore: Pointer(score)},
+			StringAlert: true,
+		}
+		assert.NoError(t, p.Validate())
+		data, err := json.Marshal(p)
+		assert.NoError(t, err)
+		assert.JSONEq(t, want, string(data))
+	}
+}
+
+func TestPayloadMarshalJSONInterruptionLevel(t *testing.T) {
+	p := Payload{APS: APS{Alert: Alert{Body: "hi"}, InteraptionLevel: "passive"}}
+	data, err := json.Marshal(p)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"aps":{"alert":{"body":"hi"},"interruption-level":"passive"}}`, string(data))
+
+	p.APS.InterruptionLevel = InterruptionLevelPassive
+	_, err = json.Marshal(p)
+	assert.True(t, errors.Is(err, errInterruptionLevelTwice))
+}
@@ -2,19 +2,52 @@ package apns
 
 import (
 	"crypto/ecdsa"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/hex"
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
+	"os"
 	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"golang.org/x/crypto/pkcs12"
 )
 
 // ClientOption defines athe APNS Client option.
 type ClientOption func(c *Client) error
 
-// WithHTTPClient sets custom HTTP Client.
+// WithName sets the name of the client, that identifies it in logs and metrics of services with many clients,
+// e.g. one per app or team. It is added as the `client` attribute to the logger set by [WithLogger], and reported
+// in [Stats] and the expvar map of [WithExpvar].
+func WithName(name string) ClientOption {
+	return func(c *Client) error {
+		c.name = name
+		return nil
+	}
+}
+
+// WithLogger sets the logger of the client, that logs token renewals and their failures, retries of sends and
+// usage of deprecated options. By default nothing is logged.
+func WithLogger(logger *slog.Logger) ClientOption {
+	return func(c *Client) error {
+		if logger == nil {
+			return errors.New("invalid logger")
+		}
+		c.logger = logger
+		return nil
+	}
+}
+
+// WithHTTPClient sets custom HTTP Client. Requests failed due to GOAWAY frame are retried only, if its transport
+// uses HTTP/2 of golang.org/x/net, e.g. configured by http2.ConfigureTransports.
 func WithHTTPClient(httpClient *http.Client) ClientOption {
 	return func(c *Client) error {
 		c.http = httpClient
@@ -30,6 +63,20 @@ func WithEndpoint(endpoint string) ClientOption {
 	}
 }
 
+// WithEndpoints distributes sends across multiple endpoints, e.g. relays in front of APNs, according to their
+// weights. An endpoint, that fails with a connection error, is skipped for 30 seconds, and the send fails over
+// to another one. It overrides [WithEndpoint], and is not meant for sending to APNs directly.
+func WithEndpoints(weights map[string]int) ClientOption {
+	return func(c *Client) error {
+		s, err := newEndpointSelector(weights)
+		if err != nil {
+			return err
+		}
+		c.endpoints = s
+		return nil
+	}
+}
+
 // WithCertificate is Option to configure TLS certificates for HTTP connection.
 // Certificates should be used with app ID, that is possible to set by
 // [WithAppID] option.
@@ -40,10 +87,30 @@ func WithCertificate(crt tls.Certificate) ClientOption {
 		}
 		config.BuildNameToCertificate()
 		c.http.Transport.(*http.Transport).TLSClientConfig = config
+		c.cert = &crt
 		return nil
 	}
 }
 
+// WithP12 is [WithCertificate] with the certificate and its private key decoded from the PKCS#12 bundle, e.g.
+// a `.p12` file exported from Keychain Access. The password may be empty.
+func WithP12(data []byte, password string) ClientOption {
+	return func(c *Client) error {
+		key, cert, err := pkcs12.Decode(data, password)
+		if errors.Is(err, pkcs12.ErrIncorrectPassword) {
+			return errors.New("decode p12: incorrect password")
+		}
+		if err != nil {
+			return fmt.Errorf("decode p12: %w", err)
+		}
+		return WithCertificate(tls.Certificate{
+			Certificate: [][]byte{cert.Raw},
+			PrivateKey:  key,
+			Leaf:        cert,
+		})(c)
+	}
+}
+
 // WithMaxIdleConnections sets maximum number of the idle HTTP connection
 // that can be reused in order do not create new connection.
 func WithMaxIdleConnections(maxIdleConn int) ClientOption {
@@ -56,9 +123,61 @@ func WithMaxIdleConnections(maxIdleConn int) ClientOption {
 	}
 }
 
+// WithMaxConnsPerHost limits the total number of HTTP connections to APNs, including connections in the dialing,
+// active, and idle states. Note, that over HTTP/2 a single connection multiplexes many concurrent requests as
+// separate streams, so a small number of connections is usually enough.
+func WithMaxConnsPerHost(maxConns int) ClientOption {
+	return func(c *Client) error {
+		if maxConns < 1 {
+			return errors.New("invalid MaxConnsPerHost")
+		}
+		c.http.Transport.(*http.Transport).MaxConnsPerHost = maxConns
+		return nil
+	}
+}
+
+// WithMaxConcurrentSends limits the number of notifications in flight at once by [Client.SendMany],
+// [Client.SendBroadcast] and [SplitByEnvironment], 1000 by default. It is independent from
+// [WithMaxIdleConnections], which limits the idle connections in the pool, not the requests in flight.
+func WithMaxConcurrentSends(n int) ClientOption {
+	return func(c *Client) error {
+		if n < 1 {
+			return errors.New("invalid MaxConcurrentSends")
+		}
+		c.maxConcurrentSends = n
+		return nil
+	}
+}
+
+// WithGlobalRateLimit caps the total number of requests per second sent by the client, to respect account-level
+// expectations of APNs and avoid being throttled. Sends wait for their turn, or fail with the context error
+// if the context is done first. The current rate is reported by [Client.Stats].
+func WithGlobalRateLimit(rps int) ClientOption {
+	return func(c *Client) error {
+		if rps < 1 {
+			return errors.New("invalid rate limit")
+		}
+		c.rateLimiter = newRateLimiter(rps)
+		return nil
+	}
+}
+
+// WithExpvar publishes the counters of the client, the same as reported by [Client.Stats], as an expvar map with
+// the given name. It gives basic observability without extra dependencies. The name must be unique within
+// the process, otherwise creating the client fails.
+func WithExpvar(name string) ClientOption {
+	return func(c *Client) error {
+		if name == "" {
+			return errors.New("invalid expvar name")
+		}
+		c.expvarName = name
+		return nil
+	}
+}
+
 // WithJWT sets the JWT config that is used to generate a JWT token to authorize against APNS to send push
 // notifications for the specified topics. The token is in Base64URL-encoded JWT format, specified as
-// `bearer <provider token>`.
+// `bearer <provider token>`. The key is parsed right away, while the first token is issued on the first send.
 func WithJWT(privateKey []byte, keyID string, teamID string) ClientOption {
 	return func(c *Client) error {
 		key, err := parsePrivateKey(privateKey)
@@ -70,13 +189,121 @@ func WithJWT(privateKey []byte, keyID string, teamID string) ClientOption {
 			KeyID:      keyID,
 			Issuer:     teamID,
 		}
+		return nil
+	}
+}
 
-		token, err := c.issueToken()
+// WithSigningMethod overrides the method the provider token is signed with, that by default is selected by
+// the curve of the private key: ES256 for P-256, ES384 for P-384 and ES512 for P-521.
+func WithSigningMethod(m jwt.SigningMethod) ClientOption {
+	return func(c *Client) error {
+		if m == nil {
+			return errors.New("invalid signing method")
+		}
+		c.signingMethod = m
+		return nil
+	}
+}
+
+// WithJWTFromFile is [WithJWT] with the private key read from the file, e.g. `AuthKey_<key ID>.p8` downloaded
+// from Apple.
+func WithJWTFromFile(path string, keyID string, teamID string) ClientOption {
+	return func(c *Client) error {
+		data, err := os.ReadFile(path)
 		if err != nil {
-			return err
+			return fmt.Errorf("read auth key %q: %w", path, err)
 		}
+		if err := WithJWT(data, keyID, teamID)(c); err != nil {
+			return fmt.Errorf("parse auth key %q: %w", path, err)
+		}
+		return nil
+	}
+}
 
-		c.sendOpts["authorization"] = WithAuthorizationToken(token)
+// WithTokenRenewedHandler sets a function that is called after each successful renewal of the provider token,
+// including the first issuance, e.g. to share the fresh token with other instances. The handler is called from
+// the renewal goroutine, or from the sending goroutine, blocking the send until it returns, if the token is issued
+// on a send, e.g. the first one or with [WithLazyTokenRenewal].
+func WithTokenRenewedHandler(handler func(token string, expiresAt time.Time)) ClientOption {
+	return func(c *Client) error {
+		c.tokenRenewedHandler = handler
+		return nil
+	}
+}
+
+// WithTokenErrorHandler sets a function that is called when the renewal of the provider token fails, e.g. because
+// the key can not be used anymore. The previous token is used until the renewal is retried successfully, and
+// the sends start failing with ErrExpiredProviderToken once it expires. The handler is called from the renewal
+// goroutine.
+func WithTokenErrorHandler(handler func(err error)) ClientOption {
+	return func(c *Client) error {
+		c.tokenErrorHandler = handler
+		return nil
+	}
+}
+
+// WithTokenRenewBackoff sets the strategy of retries of the failed token renewal, e.g. due to an outage of
+// the signing service, so it is neither retried in a tight loop nor waits for the next regular renewal, keeping
+// the token as fresh as possible once the signing recovers. By default the failed renewal is retried on the next
+// tick of [WithTokenRenewInterval], or after a minute.
+func WithTokenRenewBackoff(b Backoff) ClientOption {
+	return func(c *Client) error {
+		c.tokenRenewBackoff = b
+		return nil
+	}
+}
+
+// WithTokenValidity sets the validity of the provider token, an hour by default. The token is renewed in
+// the background once 90% of the validity has passed. It can not exceed an hour, as APNs rejects older tokens,
+// and should not be less than 20 minutes, as APNs throttles more frequent renewals with
+// ErrTooManyProviderTokenUpdates.
+func WithTokenValidity(d time.Duration) ClientOption {
+	return func(c *Client) error {
+		if d <= 0 || d > defaultTokenValidityInterval {
+			return errors.New("invalid token validity")
+		}
+		c.tokenValidity = d
+		return nil
+	}
+}
+
+// WithTokenRenewInterval renews the provider token in the background once it is older than the interval, instead
+// of once 90% of the token validity has passed. The interval must be less than the token validity.
+func WithTokenRenewInterval(d time.Duration) ClientOption {
+	return func(c *Client) error {
+		if d <= 0 {
+			return errors.New("invalid token renew interval")
+		}
+		c.tokenRenewInterval = d
+		return nil
+	}
+}
+
+// WithLazyTokenRenewal renews the provider token right before a send, if the token is older than maxAge, instead of
+// renewing it periodically in the background. It suits low-traffic or serverless deployments, where a background
+// goroutine is not desirable. The maxAge must be less than the token validity, see [WithTokenValidity], as APNs
+// rejects expired tokens, and not less than 20 minutes, as APNs throttles more frequent renewals with
+// [ErrTooManyProviderTokenUpdates].
+func WithLazyTokenRenewal(maxAge time.Duration) ClientOption {
+	return func(c *Client) error {
+		if maxAge < minTokenRefreshInterval {
+			return errors.New("invalid token max age")
+		}
+		c.lazyTokenRenewal = maxAge
+		return nil
+	}
+}
+
+// WithTokenManager sets the TokenManager the provider token of the specified team is looked up from on each send.
+// It is an alternative to [WithJWT] for services, that manage many keys and renew them on a shared schedule,
+// and can not be combined with it.
+func WithTokenManager(m *TokenManager, teamID string) ClientOption {
+	return func(c *Client) error {
+		if m == nil {
+			return errors.New("invalid token manager")
+		}
+		c.tokenManager = m
+		c.tokenTeamID = teamID
 		return nil
 	}
 }
@@ -90,10 +317,12 @@ func WithBundleID(bundleID string) ClientOption {
 			return errors.New("invalid bundle ID")
 		}
 
+		c.topic = bundleID
 		c.sendOpts["apns-topic"] = func(h http.Header) {
 			h.Set("apns-topic", bundleID)
 		}
 
+		c.deprecated = append(c.deprecated, deprecation{option: "WithBundleID", replacement: "WithAppID"})
 		return nil
 	}
 }
@@ -108,6 +337,7 @@ func WithAppID(appID string) ClientOption {
 			return errors.New("invalid application ID")
 		}
 
+		c.topic = appID
 		c.sendOpts["apns-topic"] = func(h http.Header) {
 			h.Set("apns-topic", appID)
 		}
@@ -116,6 +346,374 @@ func WithAppID(appID string) ClientOption {
 	}
 }
 
+// WithTopicValidator sets a function that checks the `apns-topic` header of each notification before it is sent.
+// It is useful to enforce a local list of allowed topics, e.g. the bundle IDs the certificate or the team is able to
+// push to, and catch misconfigured topics without a round trip to APNs. If the validator returns an error,
+// the notification is not sent and the error is returned by [Client.Send].
+func WithTopicValidator(validator func(topic string) error) ClientOption {
+	return func(c *Client) error {
+		if validator == nil {
+			return errors.New("invalid topic validator")
+		}
+		c.topicValidator = validator
+		return nil
+	}
+}
+
+// WithDeadlineFromExpiration bounds each request by the `apns-expiration` time of the notification, set by
+// [WithExpiration]. The send is aborted if it can not complete before the notification would expire anyway.
+// Notifications without expiration or with expiration 0 are not affected.
+func WithDeadlineFromExpiration() ClientOption {
+	return func(c *Client) error {
+		c.deadlineFromExpiration = true
+		return nil
+	}
+}
+
+// WithCaptureResponseBody keeps the raw body of each APNs response in [Response].Body.
+func WithCaptureResponseBody() ClientOption {
+	return func(c *Client) error {
+		c.captureResponseBody = true
+		return nil
+	}
+}
+
+// WithConnTrace collects connection diagnostics of each request, such as connection reuse and DNS, connect and
+// TLS handshake timings, and exposes them in [Response].ConnTrace. The Response is then returned alongside
+// transport and server errors too, so the diagnostics of failed requests are kept. It is off by default,
+// as tracing adds overhead to every request.
+func WithConnTrace() ClientOption {
+	return func(c *Client) error {
+		c.connTrace = true
+		return nil
+	}
+}
+
+// WithCollapseIDFunc sets a function that computes the collapse ID of each notification from its payload.
+// It is applied before the send options, so [WithCollapseID] still overrides it, and an empty result leaves
+// the notification without a collapse ID. A result longer than 64 bytes fails the send with [ErrBadCollapseID],
+// use [NormalizeCollapseID] to fit arbitrary keys.
+func WithCollapseIDFunc(fn func(p Payload) string) ClientOption {
+	return func(c *Client) error {
+		c.collapseIDFunc = fn
+		return nil
+	}
+}
+
+// WithDedupCache skips notifications, whose ID set by [WithNotificationID] was successfully sent within the ttl,
+// and returns a [Response] with Deduplicated set instead. It makes re-sends of at-least-once pipelines idempotent
+// on the client side, complementing the short deduplication window of APNs. The cache holds at most size IDs,
+// evicting the least recently sent ones, and is safe for concurrent use. Notifications without ID are not affected.
+func WithDedupCache(size int, ttl time.Duration) ClientOption {
+	return func(c *Client) error {
+		if size < 1 || ttl <= 0 {
+			return errors.New("invalid dedup cache size or TTL")
+		}
+		c.dedup = newDedupCache(size, ttl)
+		return nil
+	}
+}
+
+// WithPayloadValidation validates each payload with [Payload.Validate] before sending, so mistakes like an empty
+// payload are reported locally, e.g. as [ErrPayloadEmpty], without a round trip to APNs. The payload is also
+// checked against the send options, e.g. priority 10 is rejected with [ErrBadPriority] for background
+// notifications.
+func WithPayloadValidation() ClientOption {
+	return func(c *Client) error {
+		c.validatePayload = true
+		return nil
+	}
+}
+
+// WithErrorDecoder sets a function that converts unsuccessful responses to errors, instead of the default mapping
+// of APNs reasons. It accommodates gateways, that wrap APNs responses into their own format. The decoder only
+// runs on non-2xx responses, the body is limited to a few kilobytes.
+func WithErrorDecoder(decoder func(status int, body []byte) error) ClientOption {
+	return func(c *Client) error {
+		c.errorDecoder = decoder
+		return nil
+	}
+}
+
+// WithTokenLifecycleHandler sets a function, that is called after each send with the device token and its
+// lifecycle event, so the caller can keep its token database clean, e.g. delete unregistered tokens. Sends,
+// that say nothing about the token, e.g. failed due to a connection error, are not reported. The handler is
+// called in a separate goroutine, so it does not block the send.
+func WithTokenLifecycleHandler(handler func(token string, event TokenEvent)) ClientOption {
+	return func(c *Client) error {
+		c.tokenLifecycleHandler = handler
+		return nil
+	}
+}
+
+// WithDefaultPushType sets the `apns-push-type` header of all notifications, see [WithPushType]. Push types,
+// that require a topic suffix, e.g. `.voip` for voip, are checked against the topic set by [WithAppID]
+// when the client is created.
+func WithDefaultPushType(t string) ClientOption {
+	return func(c *Client) error {
+		if t == "" {
+			return errors.New("invalid push type")
+		}
+		c.pushType = t
+
+		c.sendOpts["apns-push-type"] = func(h http.Header) {
+			h.Set("apns-push-type", t)
+		}
+		return nil
+	}
+}
+
+// WithPushTypeInference sets the `apns-push-type: alert` header for notifications with the critical
+// or time-sensitive interruption level, if no push type is set by [WithDefaultPushType] or [WithPushType].
+// Note, that the critical level also requires the critical alerts entitlement of the app.
+func WithPushTypeInference() ClientOption {
+	return func(c *Client) error {
+		c.inferPushType = true
+		return nil
+	}
+}
+
+// WithMinOSFiltering strips the aps keys, that are not supported by iOS before the version, from all payloads, e.g.
+// `interruption-level` before iOS 15, so older devices don't receive keys that confuse them. The version is in
+// the form `major[.minor]`, e.g. "15" or "16.1".
+func WithMinOSFiltering(version string) ClientOption {
+	return func(c *Client) error {
+		v, err := parseOSVersion(version)
+		if err != nil {
+			return err
+		}
+		c.minOS = v
+		return nil
+	}
+}
+
+// topicSuffixes are the suffixes of the topic, that APNs requires for push types.
+var topicSuffixes = map[string]string{
+	"voip":         ".voip",
+	"complication": ".complication",
+	"fileprovider": ".pushkit.fileprovider",
+	"location":     ".location-query",
+	"liveactivity": ".push-type.liveactivity",
+	"pushtotalk":   ".voip-ptt",
+}
+
+// validateTopic checks that the topic has the suffix required by the push type.
+func validateTopic(topic string, pushType string) error {
+	suffix, ok := topicSuffixes[pushType]
+	if ok && !strings.HasSuffix(topic, suffix) {
+		return fmt.Errorf("topic %q of push type %s must have suffix %q", topic, pushType, suffix)
+	}
+	return nil
+}
+
+// WithTokenRedaction ensures device tokens never appear in errors and diagnostics of the client, they are
+// replaced with a stable hash computed by [HashToken]. It applies to returned errors, [WithCurlDump],
+// [WithVerboseLogging] even with [WithUnredactedDumps], the request passed to [WithRoundTripHook], and
+// the token passed to [WithTokenLifecycleHandler], so it must be matched against the stored hashes.
+func WithTokenRedaction() ClientOption {
+	return func(c *Client) error {
+		c.redactTokens = true
+		return nil
+	}
+}
+
+// WithCoalesce buffers sends for up to window, or until maxBatch sends are collected, and then dispatches them
+// concurrently over the HTTP/2 connection. It improves throughput for extremely high rates of sends, at the cost
+// of up to window of extra latency for each send: a send, that starts a batch, waits the whole window, unless
+// the batch is filled earlier. So the window should be small compared to the request timeout, and at low rates
+// the option only adds latency. Each Send still waits for and returns its own response. [Client.Close] dispatches
+// the buffered sends and waits for them to complete, and after it sends are dispatched directly.
+func WithCoalesce(window time.Duration, maxBatch int) ClientOption {
+	return func(c *Client) error {
+		if window <= 0 || maxBatch < 1 {
+			return errors.New("invalid coalesce window or batch size")
+		}
+		c.coalescer = newCoalescer(window, maxBatch)
+		return nil
+	}
+}
+
+// WithRetry retries a failed send immediately, until maxAttempts sends are made, if the error is temporary, e.g.
+// a connection error or [ErrServiceUnavailable]. Permanent errors, e.g. [ErrBadDeviceToken], are returned
+// immediately. Retries stop, when the context of the send is done. It replaces [WithRetryPolicy].
+func WithRetry(maxAttempts int) ClientOption {
+	return func(c *Client) error {
+		if maxAttempts < 1 {
+			return errors.New("invalid max attempts")
+		}
+		c.retryPolicy = maxAttemptsPolicy(maxAttempts)
+		return nil
+	}
+}
+
+// WithBackoff waits between retries of [WithRetry] or [WithRetryPolicy] with exponential backoff and full jitter,
+// see [ExponentialBackoff]: the wait doubles with each retry from base up to maxWait. If the policy returns a longer
+// wait, it is used. If the context of the send is done while waiting, the send returns the context error.
+func WithBackoff(base, maxWait time.Duration) ClientOption {
+	return func(c *Client) error {
+		if base <= 0 || maxWait < base {
+			return errors.New("invalid backoff")
+		}
+		c.retryBackoff = ExponentialBackoff{Base: base, Max: maxWait}
+		return nil
+	}
+}
+
+// WithRetryPolicy sets the policy, that decides whether a failed send is retried, e.g. [DefaultRetryPolicy].
+// If APNs responds with `Retry-After` header, the wait before the retry is at least its value. Retries stop, when
+// the context of the send is done, and the send returns the context error. By default sends are not retried.
+func WithRetryPolicy(policy RetryPolicy) ClientOption {
+	return func(c *Client) error {
+		c.retryPolicy = policy
+		return nil
+	}
+}
+
+// WithCurlDump writes each outgoing request as a copy-pasteable `curl` command to w, to reproduce APNs issues
+// manually. The provider token in the authorization header is redacted, unless [WithUnredactedDumps] is used,
+// and the device token is redacted, if [WithTokenRedaction] is used. Writes are serialized.
+func WithCurlDump(w io.Writer) ClientOption {
+	return func(c *Client) error {
+		c.curlDump = w
+		return nil
+	}
+}
+
+// WithVerboseLogging writes each request and its response, including headers and bodies, to w, for development
+// and troubleshooting. The provider token and the device token are redacted, unless [WithUnredactedDumps] is used,
+// while [WithTokenRedaction] keeps the device token redacted anyway.
+// It must not be used in production, as payloads may contain user data.
+func WithVerboseLogging(w io.Writer) ClientOption {
+	return func(c *Client) error {
+		c.verboseLog = w
+		return nil
+	}
+}
+
+// WithRoundTripHook sets a function, that is called after each request to APNs with the response, the error, if
+// the request failed, and the latency of the round trip, e.g. to record metrics. The response is nil, if none is
+// received. The response body is already read, when the hook is called. The hook is called from the sending goroutine,
+// blocking the send until it returns.
+func WithRoundTripHook(hook func(req *http.Request, resp *http.Response, err error, latency time.Duration)) ClientOption {
+	return func(c *Client) error {
+		c.roundTripHook = hook
+		return nil
+	}
+}
+
+// WithUnredactedDumps includes the authorization header into requests written by [WithCurlDump], and the provider
+// and device tokens into round trips written by [WithVerboseLogging]. The device token is still redacted, if
+// [WithTokenRedaction] is used. Note, that the dumped provider token allows
+// anyone to send notifications until it expires.
+func WithUnredactedDumps() ClientOption {
+	return func(c *Client) error {
+		c.unredactedDumps = true
+		return nil
+	}
+}
+
+// WithRequestTimeout limits the time of each request to APNs, including reading the response. The deadline of
+// the context passed to Send still applies, if it is earlier. By default requests, which context has no deadline,
+// are limited to 30 seconds, see [WithNoDefaultTimeout].
+func WithRequestTimeout(timeout time.Duration) ClientOption {
+	return func(c *Client) error {
+		if timeout <= 0 {
+			return errors.New("invalid request timeout")
+		}
+		c.requestTimeout = timeout
+		return nil
+	}
+}
+
+// WithPriorityTimeouts sets the timeout of requests by their priority, e.g. a shorter one for priority 10, that
+// is delivered immediately, than for priority 5. A notification without priority is treated as priority 10, as
+// APNs does. Requests of other priorities use [WithRequestTimeout] or the default timeout.
+func WithPriorityTimeouts(timeouts map[int]time.Duration) ClientOption {
+	return func(c *Client) error {
+		for priority, timeout := range timeouts {
+			if timeout <= 0 {
+				return fmt.Errorf("invalid timeout of priority %d", priority)
+			}
+		}
+		c.priorityTimeouts = timeouts
+		return nil
+	}
+}
+
+// WithOperationTimeout limits the total time of each Send, including all retries, backoffs and the renewal of
+// an expired provider token, unlike [WithRequestTimeout], that limits a single request.
+func WithOperationTimeout(timeout time.Duration) ClientOption {
+	return func(c *Client) error {
+		if timeout <= 0 {
+			return errors.New("invalid operation timeout")
+		}
+		c.operationTimeout = timeout
+		return nil
+	}
+}
+
+// WithNoDefaultTimeout disables the default 30 seconds timeout of requests, which context has no deadline.
+// Such requests are limited only by the connection, a stalled one can hang them forever.
+func WithNoDefaultTimeout() ClientOption {
+	return func(c *Client) error {
+		c.noDefaultTimeout = true
+		return nil
+	}
+}
+
+// WithPriorityIsolation sends high-priority notifications, i.e. voip or with priority 10 set by [WithPriority],
+// over a dedicated connection, so they are not queued behind bulk sends on a shared one. The dedicated connection
+// uses a clone of the HTTP transport, that must be *http.Transport. Note, that APNs treats a notification without
+// priority as priority 10, but it is sent over the shared connection.
+func WithPriorityIsolation() ClientOption {
+	return func(c *Client) error {
+		c.priorityIsolation = true
+		return nil
+	}
+}
+
+// WithEncoder sets the encoder of requests for gateways with a non-standard format, e.g. [BatchEncoder] for
+// a relay, set by [WithEndpoint]. The default [APNsEncoder] must be used with APNs.
+func WithEncoder(e Encoder) ClientOption {
+	return func(c *Client) error {
+		c.encoder = e
+		return nil
+	}
+}
+
+// WithRequireResponseID makes a successful send without `apns-id` header in the response fail with
+// [ErrMissingResponseID], so a misbehaving intermediary, that strips it, is detected. APNs always sets the header.
+func WithRequireResponseID() ClientOption {
+	return func(c *Client) error {
+		c.requireResponseID = true
+		return nil
+	}
+}
+
+// WithSuccessStatusCodes sets the HTTP status codes, that mean the notification is accepted, e.g. 202 of a relay
+// gateway. APNs responds only with 200 on success, that is the default, so it must not be used with APNs directly.
+func WithSuccessStatusCodes(codes ...int) ClientOption {
+	return func(c *Client) error {
+		if len(codes) == 0 {
+			return errors.New("no success status codes")
+		}
+		c.successCodes = make(map[int]bool, len(codes))
+		for _, code := range codes {
+			c.successCodes[code] = true
+		}
+		return nil
+	}
+}
+
+// WithDedupBatch sends a notification only once to each device token passed to [Client.SendBroadcast] more than
+// once, and reports the repeated tokens in [Progress]. Repeated sends waste quota and may be throttled by APNs.
+func WithDedupBatch() ClientOption {
+	return func(c *Client) error {
+		c.dedupBatch = true
+		return nil
+	}
+}
+
 // SendOption allows to set custom Headers for each notification, such as apns-id,
 // expiration time, priority, etc.
 type SendOption func(h http.Header)
@@ -137,13 +735,42 @@ func WithNotificationID(id string) SendOption {
 // APNs stores the notification and tries to deliver it at least once, repeating
 // the attempt as needed if it is unable to deliver the notification the first time.
 // If the value is 0, APNs treats the notification as if it expires immediately
-// and does not store the notification or attempt to redeliver it.
+// and does not store the notification or attempt to redeliver it. A negative value
+// fails the send with [ErrBadExpirationDate] without sending it. If the date passes,
+// while the notification waits to be sent, it is dropped as with [WithTTL].
 func WithExpiration(timeExpr int) SendOption {
 	return func(h http.Header) {
 		h.Set("apns-expiration", strconv.Itoa(timeExpr))
 	}
 }
 
+// WithTTL sets the expiration of the notification to ttl from the start of the send, rounded up to a second, as
+// APNs expects a UNIX epoch in seconds. The option can be reused for many sends. If the notification is still not
+// sent by then, e.g. it has waited in the queue of [WithCoalesce] or for retries, it is dropped locally and the send
+// fails with [ErrExpiredBeforeSend].
+func WithTTL(ttl time.Duration) SendOption {
+	return func(h http.Header) {
+		expiresAt := time.Now().Add(ttl)
+		exp := expiresAt.Unix()
+		if expiresAt.Nanosecond() > 0 {
+			exp++
+		}
+		h.Set("apns-expiration", strconv.FormatInt(exp, 10))
+	}
+}
+
+// Priorities of the notification, that APNs accepts.
+const (
+	// PriorityLow prioritizes the device power considerations over all other factors for delivery, and prevents
+	// awakening the device.
+	PriorityLow = 1
+	// PriorityNormal sends the notification at a time that takes into account power considerations for
+	// the device.
+	PriorityNormal = 5
+	// PriorityHigh sends the notification immediately.
+	PriorityHigh = 10
+)
+
 // WithPriority specifies the  priority of the notification.
 // Specify one of the following values:
 // * 10 - Send the push message immediately. Notifications with this priority
@@ -153,6 +780,9 @@ func WithExpiration(timeExpr int) SendOption {
 // * 5 - Send the push message at a time that takes into account power
 // considerations for the device. Notifications with this priority might be grouped
 // and delivered in bursts. They are throttled, and in some cases are not delivered.
+// * 1 - Prioritize the device’s power considerations over all other factors for delivery,
+// and prevent awakening the device.
+// Other values are rejected by [Client.Send] with [ErrBadPriority].
 func WithPriority(priority int) SendOption {
 	return func(h http.Header) {
 		h.Set("apns-priority", strconv.Itoa(priority))
@@ -168,6 +798,35 @@ func WithCollapseID(id string) SendOption {
 	}
 }
 
+// WithDefaultCollapseID sets the collapse ID, if no collapse ID is set by preceding send options or by
+// [WithCollapseIDFunc], so it does not override a more specific one. Pass it after other send options.
+func WithDefaultCollapseID(id string) SendOption {
+	return func(h http.Header) {
+		if h.Get("apns-collapse-id") == "" {
+			h.Set("apns-collapse-id", id)
+		}
+	}
+}
+
+// maxCollapseIDSize is the maximum size of the `apns-collapse-id` header in bytes.
+const maxCollapseIDSize = 64
+
+// NormalizeCollapseID deterministically maps an arbitrary string, e.g. a long message key, to a valid collapse ID.
+// The result is the hex-encoded SHA-256 hash of the string, which is exactly 64 bytes long. It is a one-way
+// transform: the original string can not be recovered from the collapse ID.
+func NormalizeCollapseID(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// WithIdempotencyKey sets the collapse ID derived from the key by [NormalizeCollapseID], so accidental duplicate
+// sends with the same key are collapsed into a single notification on the device. Unlike the notification ID,
+// it relies on the collapse behavior of the device, rather than on deduplication. Keys of any length are
+// accepted, as the derived collapse ID always fits the 64-byte limit.
+func WithIdempotencyKey(key string) SendOption {
+	return WithCollapseID(NormalizeCollapseID(key))
+}
+
 // WithPushType sets a value of the `apns-push-type` header that accurately reflect the contents of your notification’s
 // payload. If there’s a mismatch, or if the header is missing on required systems, APNs may return an error, delay the
 // delivery of the notification, or drop it altogether.
@@ -207,13 +866,24 @@ func WithPushType(t string) SendOption {
 	}
 }
 
-// WithAuthorizationToken sets `Authorization` header with a bearer token.
+// WithAuthorizationToken sets `Authorization` header with a bearer token. Used as a send option, it overrides
+// the token of the client for a single notification, e.g. to send with a token of another key.
 func WithAuthorizationToken(t string) SendOption {
 	return func(h http.Header) {
 		h.Set("authorization", fmt.Sprintf("bearer %s", t))
 	}
 }
 
+// WithoutAuthorizationToken removes `Authorization` header set by the client, so the notification is authorized
+// by the TLS certificate of the connection instead. It allows to choose the authorization method per send,
+// e.g. during a migration from certificate to token authorization, when the client is configured with both
+// [WithCertificate] and [WithJWT].
+func WithoutAuthorizationToken() SendOption {
+	return func(h http.Header) {
+		h.Del("authorization")
+	}
+}
+
 func parsePrivateKey(key []byte) (*ecdsa.PrivateKey, error) {
 	block, _ := pem.Decode(key)
 	if block == nil {
@@ -221,11 +891,19 @@ func parsePrivateKey(key []byte) (*ecdsa.PrivateKey, error) {
 	}
 	pKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
 	if err != nil {
-		return nil, err
+		// Keys exported by OpenSSL are often in the SEC1 encoding, "EC PRIVATE KEY".
+		ecKey, ecErr := x509.ParseECPrivateKey(block.Bytes)
+		if ecErr != nil {
+			return nil, fmt.Errorf("parse private key as PKCS#8: %v, as SEC1: %v", err, ecErr)
+		}
+		pKey = ecKey
 	}
 	k, ok := pKey.(*ecdsa.PrivateKey)
 	if !ok {
 		return nil, errors.New("not ECDSA private key")
 	}
+	if _, err := signingMethodFor(k.Curve); err != nil {
+		return nil, err
+	}
 	return k, nil
 }
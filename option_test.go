@@ -0,0 +1,163 @@
+package apns
+
+import (
+	"bytes"
+	"context"
+	"expvar"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithMaxConnsPerHost(t *testing.T) {
+	_, err := NewClient(context.Background(), WithMaxConnsPerHost(0))
+	assert.Error(t, err)
+
+	c, err := NewClient(context.Background(), WithMaxConnsPerHost(4))
+	assert.NoError(t, err)
+	assert.Equal(t, c.http.Transport.(*http.Transport).MaxConnsPerHost, 4)
+}
+
+func TestWithMaxConcurrentSends(t *testing.T) {
+	_, err := NewClient(context.Background(), WithMaxConcurrentSends(0))
+	assert.Error(t, err)
+
+	c, err := NewClient(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, c.maxConcurrentSends, defaultMaxConcurrentSends)
+
+	c, err = NewClient(context.Background(), WithMaxConcurrentSends(8))
+	assert.NoError(t, err)
+	assert.Equal(t, c.maxConcurrentSends, 8)
+}
+
+func TestNormalizeCollapseID(t *testing.T) {
+	for _, s := range []string{"", "short", strings.Repeat("long message key ", 10)} {
+		id := NormalizeCollapseID(s)
+		assert.Len(t, id, 64)
+		assert.Equal(t, id, NormalizeCollapseID(s))
+	}
+	assert.NotEqual(t, NormalizeCollapseID("a"), NormalizeCollapseID("b"))
+}
+
+func TestWithExpvar(t *testing.T) {
+	c, err := NewClient(context.Background(), WithExpvar("apns_test"))
+	assert.NoError(t, err)
+	c.stats.record(nil)
+	c.stats.record(ErrBadDeviceToken)
+
+	m := expvar.Get("apns_test").(*expvar.Map)
+	assert.Equal(t, m.Get("sent").String(), "1")
+	assert.Equal(t, m.Get("rejected").String(), "1")
+	assert.Equal(t, c.Stats().Failed, uint64(1))
+
+	_, err = NewClient(context.Background(), WithExpvar("apns_test"))
+	assert.EqualError(t, err, `expvar "apns_test" is already published`)
+
+	// Clients created concurrently with the same name do not panic, only one of them publishes it.
+	errs := make(chan error, 10)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			_, err := New(WithExpvar("apns_test_concurrent"))
+			errs <- err
+		}()
+	}
+	var published int
+	for i := 0; i < cap(errs); i++ {
+		if <-errs == nil {
+			published++
+		}
+	}
+	assert.Equal(t, published, 1)
+}
+
+func TestWithDefaultPushType(t *testing.T) {
+	_, err := NewClient(context.Background(),
+		WithAppID("com.example.app"),
+		WithDefaultPushType("voip"),
+	)
+	assert.EqualError(t, err, `topic "com.example.app" of push type voip must have suffix ".voip"`)
+
+	_, err = NewClient(context.Background(),
+		WithDefaultPushType("voip"),
+		WithAppID("com.example.app.voip"),
+	)
+	assert.NoError(t, err)
+
+	_, err = NewClient(context.Background(),
+		WithAppID("com.example.app"),
+		WithDefaultPushType("alert"),
+	)
+	assert.NoError(t, err)
+}
+
+func TestWithIdempotencyKey(t *testing.T) {
+	h := make(http.Header)
+	WithIdempotencyKey("order-42/shipped")(h)
+	assert.Equal(t, h.Get("apns-collapse-id"), NormalizeCollapseID("order-42/shipped"))
+}
+
+func TestWithDefaultCollapseID(t *testing.T) {
+	h := make(http.Header)
+	WithDefaultCollapseID("default")(h)
+	assert.Equal(t, h.Get("apns-collapse-id"), "default")
+
+	h = make(http.Header)
+	WithCollapseID("explicit")(h)
+	WithDefaultCollapseID("default")(h)
+	assert.Equal(t, h.Get("apns-collapse-id"), "explicit")
+}
+
+func TestDeprecatedOptionWarning(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+
+	_, err := New(WithBundleID("com.example.app"), WithLogger(logger))
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), `level=WARN msg="apns: deprecated option is used" option=WithBundleID replacement=WithAppID`)
+
+	buf.Reset()
+	_, err = New(WithAppID("com.example.app"), WithLogger(logger))
+	assert.NoError(t, err)
+	assert.Empty(t, buf.String())
+
+	_, err = New(WithLogger(nil))
+	assert.EqualError(t, err, "invalid logger")
+}
+
+func TestWithName(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+
+	c, err := New(WithName("app"), WithBundleID("com.example.app"), WithLogger(logger), WithExpvar("apns_test_name"))
+	assert.NoError(t, err)
+	assert.Equal(t, c.Name(), "app")
+	assert.Contains(t, buf.String(), `msg="apns: deprecated option is used" client=app option=WithBundleID`)
+	assert.Equal(t, c.Stats().Name, "app")
+	assert.Equal(t, expvar.Get("apns_test_name").(*expvar.Map).Get("name").String(), `"app"`)
+}
+
+func TestWithP12(t *testing.T) {
+	data, err := os.ReadFile("testdata/cert.p12")
+	assert.NoError(t, err)
+
+	c, err := New(WithP12(data, "secret"), WithAppID("com.example.app"))
+	assert.NoError(t, err)
+	assert.Equal(t, "Apple Push Services: com.example.app", c.cert.Leaf.Subject.CommonName)
+	assert.NoError(t, c.Validate())
+
+	_, err = New(WithP12(data, "wrong"))
+	assert.EqualError(t, err, "decode p12: incorrect password")
+
+	data, err = os.ReadFile("testdata/cert_nopass.p12")
+	assert.NoError(t, err)
+	_, err = New(WithP12(data, ""))
+	assert.NoError(t, err)
+
+	_, err = New(WithP12([]byte("garbage"), ""))
+	assert.Error(t, err)
+}
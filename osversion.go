@@ -0,0 +1,76 @@
+package apns
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// osVersion is an iOS version, the zero value means any version.
+type osVersion struct {
+	major, minor int
+}
+
+// parseOSVersion parses a version in the form `major[.minor]`, e.g. "15" or "16.1".
+func parseOSVersion(s string) (osVersion, error) {
+	majorStr, minorStr, hasMinor := strings.Cut(s, ".")
+	major, err := strconv.Atoi(majorStr)
+	if err != nil || major < 1 {
+		return osVersion{}, fmt.Errorf("invalid OS version %q", s)
+	}
+	var minor int
+	if hasMinor {
+		if minor, err = strconv.Atoi(minorStr); err != nil || minor < 0 {
+			return osVersion{}, fmt.Errorf("invalid OS version %q", s)
+		}
+	}
+	return osVersion{major: major, minor: minor}, nil
+}
+
+func (v osVersion) less(o osVersion) bool {
+	return v.major < o.major || v.major == o.major && v.minor < o.minor
+}
+
+// apsMinOS maps aps keys to the first iOS version, that supports them. Keys, that are not listed, are supported
+// by all versions.
+var apsMinOS = map[string]osVersion{
+	"target-content-id":  {13, 0},
+	"interruption-level": {15, 0},
+	"relevance-score":    {15, 0},
+	"filter-criteria":    {16, 0},
+	"stale-date":         {16, 1},
+	"content-state":      {16, 1},
+	"timestamp":          {16, 1},
+	"event":              {16, 1},
+}
+
+// marshal converts the payload to JSON, stripping the aps keys, that are not supported by minOS. RawAPS is kept
+// verbatim.
+func (p Payload) marshal(minOS osVersion) ([]byte, error) {
+	if minOS == (osVersion{}) || p.RawAPS != nil {
+		return json.Marshal(p)
+	}
+
+	p.APS.stringAlert = p.StringAlert
+	data, err := json.Marshal(p.APS)
+	if err != nil {
+		return nil, err
+	}
+	var aps map[string]json.RawMessage
+	if err := json.Unmarshal(data, &aps); err != nil {
+		return nil, err
+	}
+	for key, v := range apsMinOS {
+		if minOS.less(v) {
+			delete(aps, key)
+		}
+	}
+
+	values, err := p.customValues()
+	if err != nil {
+		return nil, err
+	}
+	values["aps"] = aps
+	return json.Marshal(values)
+}
@@ -0,0 +1,39 @@
+package apns
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPayloadMarshalMinOS(t *testing.T) {
+	p := Payload{
+		APS: APS{
+			Alert:             Alert{Body: "hi"},
+			InterruptionLevel: InterruptionLevelTimeSensitive,
+			FilterCriteria:    "work",
+		},
+		CustomValues: map[string]any{"key": "value"},
+	}
+
+	data, err := p.marshal(osVersion{})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"aps":{"alert":{"body":"hi"},"interruption-level":"time-sensitive","filter-criteria":"work"},"key":"value"}`, string(data))
+
+	v, err := parseOSVersion("15.4")
+	assert.NoError(t, err)
+	data, err = p.marshal(v)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"aps":{"alert":{"body":"hi"},"interruption-level":"time-sensitive"},"key":"value"}`, string(data))
+
+	v, err = parseOSVersion("14")
+	assert.NoError(t, err)
+	data, err = p.marshal(v)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"aps":{"alert":{"body":"hi"}},"key":"value"}`, string(data))
+
+	for _, s := range []string{"", "x", "15.", "0", "15.-1"} {
+		_, err := parseOSVersion(s)
+		assert.Error(t, err, s)
+	}
+}
@@ -0,0 +1,98 @@
+package apns
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket, that allows rate requests per second with bursts of the same size.
+type rateLimiter struct {
+	rate float64
+
+	mtx    sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rps int) *rateLimiter {
+	return &rateLimiter{
+		rate:   float64(rps),
+		tokens: float64(rps),
+		last:   time.Now(),
+	}
+}
+
+// wait blocks until a request is allowed or the context is done.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	l.mtx.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+	// Reserve the token in advance, so waiting requests are served in order.
+	l.tokens--
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mtx.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.mtx.Lock()
+		l.tokens++
+		l.mtx.Unlock()
+		return ctx.Err()
+	}
+}
+
+// rateMeter measures the number of events per second.
+type rateMeter struct {
+	mtx      sync.Mutex
+	start    time.Time
+	count    int
+	rate     float64
+	measured bool
+}
+
+func (m *rateMeter) mark() {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	now := time.Now()
+	if m.start.IsZero() {
+		m.start = now
+	} else if elapsed := now.Sub(m.start); elapsed >= time.Second {
+		m.rate = float64(m.count) / elapsed.Seconds()
+		m.measured = true
+		m.start = now
+		m.count = 0
+	}
+	m.count++
+}
+
+func (m *rateMeter) current() float64 {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if m.start.IsZero() {
+		return 0
+	}
+	// Use the current window, if it is complete or there is no previous one yet.
+	if elapsed := time.Since(m.start); elapsed >= time.Second || !m.measured {
+		return float64(m.count) / elapsed.Seconds()
+	}
+	return m.rate
+}
@@ -0,0 +1,24 @@
+package apns
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(100)
+
+	start := time.Now()
+	for i := 0; i < 110; i++ {
+		assert.NoError(t, l.wait(context.Background()))
+	}
+	// The burst is allowed at once, the rest waits for the bucket to refill.
+	assert.True(t, time.Since(start) >= 90*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, l.wait(ctx), context.Canceled)
+}
@@ -0,0 +1,42 @@
+package apns
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// HashToken returns a stable hash of the device token, that can be logged instead of the token itself.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:8])
+}
+
+// redactURL replaces the device token, the last segment of the request path, with its hash.
+func redactURL(u string) string {
+	i := strings.LastIndex(u, "/")
+	if i < 0 || i == len(u)-1 {
+		return u
+	}
+	return u[:i+1] + HashToken(u[i+1:])
+}
+
+// redactRequest returns a copy of the request, whose URL has the device token replaced with its hash.
+func redactRequest(req *http.Request) *http.Request {
+	redacted := req.Clone(req.Context())
+	redacted.URL.Path = redactURL(redacted.URL.Path)
+	redacted.URL.RawPath = ""
+	return redacted
+}
+
+// redactError removes the device token from the URL of the transport error.
+func redactError(err error) error {
+	if ue, ok := err.(*url.Error); ok {
+		redacted := *ue
+		redacted.URL = redactURL(ue.URL)
+		return &redacted
+	}
+	return err
+}
@@ -1,8 +1,14 @@
 package apns
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
 )
 
 // Possible error codes included in the reason key of a response’s JSON payload.
@@ -15,7 +21,7 @@ var (
 	ErrBadTopic                    = errors.New("apns-topic was invalid")
 	ErrDeviceTokenNotForTopic      = errors.New("device token does not match the specified topic")
 	ErrDuplicateHeaders            = errors.New("one or more headers were repeated")
-	ErrIdleTimeout                 = connError("idle time out")
+	ErrIdleTimeout                 = &connError{msg: "idle time out"}
 	ErrMissingDeviceToken          = errors.New("device token is not specified in the request path")
 	ErrMissingTopic                = errors.New("apns-topic header of the request was not specified and was required")
 	ErrPayloadEmpty                = errors.New("message payload was empty")
@@ -37,6 +43,28 @@ var (
 	ErrShutdown                    = serverError("the server is shutting down")
 )
 
+// UnknownReasonError is the error of a response with a reason, that is not known to the package, e.g. one added
+// by Apple recently. Known reasons are mapped to the errors above.
+type UnknownReasonError struct {
+	Reason string
+}
+
+func (e *UnknownReasonError) Error() string {
+	return fmt.Sprintf("apns: unknown reason %q", e.Reason)
+}
+
+// ErrTimeout is returned when the request deadline is exceeded before APNs responds. Unlike [ErrIdleTimeout],
+// which is reported by APNs, it is a client-side timeout.
+var ErrTimeout = timeoutError("request timed out")
+
+// ErrExpiredBeforeSend is returned, if the notification with [WithTTL] or [WithExpiration] has expired before it
+// is sent, so it is dropped locally.
+var ErrExpiredBeforeSend = errors.New("notification expired before send")
+
+// ErrMissingResponseID is returned, if APNs accepted the notification, but the response has no `apns-id` header,
+// see [WithRequireResponseID].
+var ErrMissingResponseID = errors.New("apns-id header is missing in the response")
+
 var errorsMapping = map[string]error{
 	"BadCollapseID":               ErrBadCollapseID,
 	"BadDeviceToken":              ErrBadDeviceToken,
@@ -68,20 +96,194 @@ var errorsMapping = map[string]error{
 	"Shutdown":                    ErrShutdown,
 }
 
-type connError string
+// KnownReasons returns all reasons APNs is known to respond with, mapped to the errors they are converted to.
+// The returned map is a copy and can be modified by the caller.
+func KnownReasons() map[string]error {
+	reasons := make(map[string]error, len(errorsMapping))
+	for reason, err := range errorsMapping {
+		reasons[reason] = err
+	}
+	return reasons
+}
+
+var statusErrors = map[int]error{
+	http.StatusMethodNotAllowed:      ErrMethodNotAllowed,
+	http.StatusGone:                  ErrUnregistered,
+	http.StatusRequestEntityTooLarge: ErrPayloadTooLarge,
+	http.StatusTooManyRequests:       ErrTooManyRequests,
+}
+
+// statusError returns an error derived from the HTTP status code, used when the response body has no valid reason.
+func statusError(code int, status string) error {
+	if err, ok := statusErrors[code]; ok {
+		return err
+	}
+	return fmt.Errorf("%d error: %s", code, status)
+}
+
+// TokenEvent is an event in the lifecycle of a device token, reported by [WithTokenLifecycleHandler].
+type TokenEvent int
+
+// Possible device token events.
+const (
+	// TokenDelivered means a notification was accepted for the device token.
+	TokenDelivered TokenEvent = iota + 1
+	// TokenUnregistered means the device token is no longer active, it should not be used anymore.
+	TokenUnregistered
+	// TokenBad means the device token is invalid, or belongs to another topic or environment.
+	TokenBad
+)
+
+func (e TokenEvent) String() string {
+	switch e {
+	case TokenDelivered:
+		return "delivered"
+	case TokenUnregistered:
+		return "unregistered"
+	case TokenBad:
+		return "bad token"
+	default:
+		return "unknown"
+	}
+}
+
+// tokenEventOf returns the device token event of a send result, if any.
+func tokenEventOf(err error) (TokenEvent, bool) {
+	switch {
+	case err == nil:
+		return TokenDelivered, true
+	case errors.Is(err, ErrUnregistered):
+		return TokenUnregistered, true
+	case errors.Is(err, ErrBadDeviceToken), errors.Is(err, ErrDeviceTokenNotForTopic):
+		return TokenBad, true
+	default:
+		return 0, false
+	}
+}
+
+// DeliveryStatus classifies the result of a send, see [Response.Status].
+type DeliveryStatus int
+
+// Possible delivery statuses.
+const (
+	// StatusDelivered means APNs accepted the notification.
+	StatusDelivered DeliveryStatus = iota + 1
+	// StatusRejected means APNs rejected the notification, e.g. due to a bad device token or payload.
+	StatusRejected
+	// StatusUnregistered means the device token is no longer active, it should not be used anymore.
+	StatusUnregistered
+	// StatusRateLimited means the notification or provider token updates are throttled.
+	StatusRateLimited
+	// StatusServerError means APNs failed to process the notification, it can be retried.
+	StatusServerError
+	// StatusAuthError means the provider token or certificate was not accepted.
+	StatusAuthError
+	// StatusConnError means the notification was not sent or its response was not received, e.g. due to
+	// a connection error, [ErrIdleTimeout] or [ErrTimeout], it can be retried.
+	StatusConnError
+)
+
+func (s DeliveryStatus) String() string {
+	switch s {
+	case StatusDelivered:
+		return "delivered"
+	case StatusRejected:
+		return "rejected"
+	case StatusUnregistered:
+		return "unregistered"
+	case StatusRateLimited:
+		return "rate limited"
+	case StatusServerError:
+		return "server error"
+	case StatusAuthError:
+		return "auth error"
+	case StatusConnError:
+		return "connection error"
+	default:
+		return "unknown"
+	}
+}
+
+// connError is a failure to reach APNs or to read its response. It wraps the error of the transport, if any,
+// so e.g. *url.Error or *net.OpError can be inspected with errors.As.
+type connError struct {
+	msg string
+	err error
+}
+
+func (e *connError) Error() string {
+	if e.err != nil {
+		return e.err.Error()
+	}
+	return e.msg
+}
+
+func (e *connError) Unwrap() error {
+	return e.err
+}
+
+func (e *connError) Temporary() bool {
+	return true
+}
+
+func (e *connError) Timeout() bool {
+	return true
+}
+
+type timeoutError string
 
-func (e connError) Error() string {
+func (e timeoutError) Error() string {
 	return string(e)
 }
 
-func (e connError) Temporary() bool {
+func (e timeoutError) Temporary() bool {
 	return true
 }
 
-func (e connError) Timeout() bool {
+func (e timeoutError) Timeout() bool {
 	return true
 }
 
+// isGoAway reports whether the request failed, because the HTTP/2 connection was closed by GOAWAY frame.
+func isGoAway(err error) bool {
+	var goAway http2.GoAwayError
+	return errors.As(err, &goAway)
+}
+
+// isUnprocessedGoAway reports whether the request failed, because the HTTP/2 connection was closed by GOAWAY frame,
+// before APNs processed any stream of it, so the request is safe to send again. Streams above the last processed
+// one are retried by the transport itself, while the rest may have been delivered.
+func isUnprocessedGoAway(err error) bool {
+	var goAway http2.GoAwayError
+	return errors.As(err, &goAway) && goAway.LastStreamID == 0
+}
+
+// TemporaryError is implemented by errors, that may not occur again, if the send is retried, e.g. connection
+// and server errors.
+type TemporaryError interface {
+	error
+	Temporary() bool
+}
+
+// TimeoutError is implemented by errors, that are caused by a timeout.
+type TimeoutError interface {
+	error
+	Timeout() bool
+}
+
+// IsTemporary reports whether any error in the chain of err is temporary, so the send may succeed, if it is
+// retried.
+func IsTemporary(err error) bool {
+	var t TemporaryError
+	return errors.As(err, &t) && t.Temporary()
+}
+
+// IsTimeout reports whether any error in the chain of err is caused by a timeout.
+func IsTimeout(err error) bool {
+	var t TimeoutError
+	return errors.As(err, &t) && t.Timeout()
+}
+
 type serverError string
 
 func (e serverError) Error() string {
@@ -98,9 +300,52 @@ func (e serverError) Timeout() bool {
 
 // Response represents response object from APN service.
 type Response struct {
+	// StatusCode is the HTTP status code of the response.
+	StatusCode     int
 	NotificationID string
-	Timestamp      int64
-	Error          error
+	// UniqueID is the value of `apns-unique-id` header, that identifies the notification for Apple Developer
+	// Support. It is set only in the development environment.
+	UniqueID  string
+	Timestamp int64
+	Error     error
+	// Body is the raw response body, set when it can not be decoded or if [WithCaptureResponseBody] is used.
+	Body []byte
+	// ConnTrace holds connection diagnostics of the request, set if [WithConnTrace] is used.
+	ConnTrace *ConnTrace
+	// Deduplicated reports whether the notification was not sent, because a notification with the same ID was
+	// sent recently, see [WithDedupCache].
+	Deduplicated bool
+	// RetryAfter is the value of `Retry-After` header, that APNs may set on [ErrTooManyRequests] and
+	// [ErrServiceUnavailable]. Retries of [WithRetry] and [WithRetryPolicy] wait at least this long.
+	RetryAfter time.Duration
+}
+
+// Status classifies the response by its error, so callers can switch on it instead of matching individual errors.
+func (r *Response) Status() DeliveryStatus {
+	var (
+		srvErr     serverError
+		connErr    *connError
+		timeoutErr timeoutError
+	)
+	switch err := r.Error; {
+	case err == nil:
+		return StatusDelivered
+	case errors.As(err, &connErr), errors.As(err, &timeoutErr),
+		errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return StatusConnError
+	case errors.Is(err, ErrUnregistered):
+		return StatusUnregistered
+	case errors.Is(err, ErrTooManyRequests), errors.Is(err, ErrTooManyProviderTokenUpdates):
+		return StatusRateLimited
+	case errors.As(err, &srvErr):
+		return StatusServerError
+	case errors.Is(err, ErrExpiredProviderToken), errors.Is(err, ErrInvalidProviderToken),
+		errors.Is(err, ErrMissingProviderToken), errors.Is(err, ErrBadCertificate),
+		errors.Is(err, ErrBadCertificateEnvironment), errors.Is(err, ErrForbidden):
+		return StatusAuthError
+	default:
+		return StatusRejected
+	}
 }
 
 // UnmarshalJSON implements json.Unmarshaler.
@@ -116,7 +361,7 @@ func (r *Response) UnmarshalJSON(data []byte) error {
 		if err, ok := errorsMapping[rawResp.Reason]; ok {
 			r.Error = err
 		} else {
-			r.Error = errors.New("unknown error")
+			r.Error = &UnknownReasonError{Reason: rawResp.Reason}
 		}
 	}
 	r.Timestamp = rawResp.Timestamp
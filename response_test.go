@@ -0,0 +1,84 @@
+package apns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKnownReasons(t *testing.T) {
+	reasons := KnownReasons()
+	assert.Equal(t, reasons, errorsMapping)
+
+	delete(reasons, "BadDeviceToken")
+	reasons["Unknown"] = nil
+	assert.Equal(t, errorsMapping["BadDeviceToken"], ErrBadDeviceToken)
+	assert.NotContains(t, errorsMapping, "Unknown")
+}
+
+func TestResponseStatus(t *testing.T) {
+	for _, tc := range []struct {
+		err    error
+		status DeliveryStatus
+	}{
+		{nil, StatusDelivered},
+		{ErrUnregistered, StatusUnregistered},
+		{ErrBadDeviceToken, StatusRejected},
+		{ErrPayloadTooLarge, StatusRejected},
+		{ErrTooManyRequests, StatusRateLimited},
+		{ErrTooManyProviderTokenUpdates, StatusRateLimited},
+		{ErrServiceUnavailable, StatusServerError},
+		{ErrShutdown, StatusServerError},
+		{ErrExpiredProviderToken, StatusAuthError},
+		{ErrBadCertificateEnvironment, StatusAuthError},
+		{ErrIdleTimeout, StatusConnError},
+		{ErrTimeout, StatusConnError},
+		{&connError{err: errors.New("connection refused")}, StatusConnError},
+		{fmt.Errorf("send: %w", ErrIdleTimeout), StatusConnError},
+		{context.DeadlineExceeded, StatusConnError},
+		{&UnknownReasonError{Reason: "SomeNewCode"}, StatusRejected},
+	} {
+		r := &Response{Error: tc.err}
+		assert.Equal(t, tc.status, r.Status(), tc.status.String())
+	}
+	assert.Equal(t, "rate limited", StatusRateLimited.String())
+	assert.Equal(t, "connection error", StatusConnError.String())
+}
+
+func TestResponseUnmarshalJSON(t *testing.T) {
+	var r Response
+	assert.NoError(t, json.Unmarshal([]byte(`{"reason":"BadDeviceToken","timestamp":1}`), &r))
+	assert.Equal(t, ErrBadDeviceToken, r.Error)
+	assert.Equal(t, int64(1), r.Timestamp)
+
+	r = Response{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"reason":"SomeNewCode"}`), &r))
+	assert.EqualError(t, r.Error, `apns: unknown reason "SomeNewCode"`)
+	var unknown *UnknownReasonError
+	assert.True(t, errors.As(r.Error, &unknown))
+	assert.Equal(t, "SomeNewCode", unknown.Reason)
+}
+
+func TestIsTemporaryAndIsTimeout(t *testing.T) {
+	for _, tc := range []struct {
+		err       error
+		temporary bool
+		timeout   bool
+	}{
+		{nil, false, false},
+		{ErrBadDeviceToken, false, false},
+		{ErrIdleTimeout, true, true},
+		{ErrTimeout, true, true},
+		{ErrServiceUnavailable, true, false},
+		{fmt.Errorf("send: %w", ErrShutdown), true, false},
+		{&RetryExhaustedError{LastError: ErrTimeout}, true, true},
+		{context.DeadlineExceeded, true, true},
+	} {
+		assert.Equal(t, tc.temporary, IsTemporary(tc.err), fmt.Sprint(tc.err))
+		assert.Equal(t, tc.timeout, IsTimeout(tc.err), fmt.Sprint(tc.err))
+	}
+}
@@ -0,0 +1,127 @@
+package apns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy decides whether a failed send is retried and how long to wait before the retry. The attempt is 1
+// for the first retry. Sends, rejected due to an expired provider token, are retried with a renewed token
+// regardless of the policy.
+type RetryPolicy func(err error, attempt int) (retry bool, wait time.Duration)
+
+// DefaultRetryPolicy retries a send up to 3 times: immediately on [ErrIdleTimeout], as the retry uses a new
+// connection, and with exponential backoff on server errors, e.g. [ErrServiceUnavailable], and
+// [ErrTooManyRequests].
+func DefaultRetryPolicy(err error, attempt int) (bool, time.Duration) {
+	if attempt > 3 {
+		return false, 0
+	}
+
+	var srvErr serverError
+	switch {
+	case errors.Is(err, ErrIdleTimeout):
+		return true, 0
+	case errors.As(err, &srvErr):
+		return true, 100 * time.Millisecond << (attempt - 1)
+	case errors.Is(err, ErrTooManyRequests):
+		return true, time.Second << (attempt - 1)
+	}
+	return false, 0
+}
+
+// RetryExhaustedError is returned, when a send failed after retries. It unwraps to the error of the last attempt.
+type RetryExhaustedError struct {
+	// Attempts is the number of sends made, including the first one.
+	Attempts int
+	// LastError is the error of the last attempt.
+	LastError error
+	// Elapsed is the time from the first attempt to the failure of the last one, including waits between them.
+	Elapsed time.Duration
+}
+
+func (e *RetryExhaustedError) Error() string {
+	return fmt.Sprintf("send failed after %d attempts in %s: %v", e.Attempts, e.Elapsed, e.LastError)
+}
+
+func (e *RetryExhaustedError) Unwrap() error {
+	return e.LastError
+}
+
+// maxAttemptsPolicy retries temporary errors, e.g. connection and server errors, until maxAttempts sends are made.
+func maxAttemptsPolicy(maxAttempts int) RetryPolicy {
+	return func(err error, attempt int) (bool, time.Duration) {
+		return attempt < maxAttempts && IsTemporary(err), 0
+	}
+}
+
+func (c *Client) sendRetrying(ctx context.Context, deviceToken string, p Payload, opts ...SendOption) (*Response, error) {
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		resp, err := c.sendRenewingToken(ctx, deviceToken, p, opts...)
+		if err == nil || c.retryPolicy == nil {
+			return resp, err
+		}
+
+		if ctx.Err() != nil {
+			return resp, ctx.Err()
+		}
+		retry, wait := c.retryPolicy(err, attempt)
+		if !retry {
+			return resp, retryExhausted(attempt, err, start)
+		}
+		if c.retryBackoff != nil {
+			if d := c.retryBackoff.Next(attempt); d > wait {
+				wait = d
+			}
+		}
+		var statusCode int
+		if resp != nil {
+			statusCode = resp.StatusCode
+			if resp.RetryAfter > wait {
+				wait = resp.RetryAfter
+			}
+		}
+		c.logger.Warn("apns: retrying notification", "attempt", attempt, "status_code", statusCode, "wait", wait, "error", err)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return resp, ctx.Err()
+		}
+	}
+}
+
+// retryExhausted wraps the error into RetryExhaustedError, if the send was retried.
+func retryExhausted(attempts int, err error, start time.Time) error {
+	if attempts == 1 {
+		return err
+	}
+	return &RetryExhaustedError{
+		Attempts:  attempts,
+		LastError: err,
+		Elapsed:   time.Since(start),
+	}
+}
+
+// parseRetryAfter parses the value of `Retry-After` header, that is either delay in seconds or HTTP date.
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if s, err := strconv.Atoi(v); err == nil && s > 0 {
+		return time.Duration(s) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
@@ -0,0 +1,298 @@
+package apns
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultRetryPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		err   error
+		retry bool
+		wait  time.Duration
+	}{
+		{ErrIdleTimeout, true, 0},
+		{ErrServiceUnavailable, true, 200 * time.Millisecond},
+		{serverError("500 error: 500 Internal Server Error"), true, 200 * time.Millisecond},
+		{ErrTooManyRequests, true, 2 * time.Second},
+		{ErrBadDeviceToken, false, 0},
+		{ErrExpiredProviderToken, false, 0},
+	} {
+		retry, wait := DefaultRetryPolicy(tc.err, 2)
+		assert.Equal(t, tc.retry, retry, tc.err.Error())
+		assert.Equal(t, tc.wait, wait, tc.err.Error())
+	}
+
+	retry, _ := DefaultRetryPolicy(ErrIdleTimeout, 4)
+	assert.False(t, retry)
+}
+
+func TestSendRetry(t *testing.T) {
+	newServer := func(fail int, status int, reason string, header http.Header) (*httptest.Server, *atomic.Int32) {
+		var calls atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			if int(calls.Add(1)) <= fail {
+				for k, v := range header {
+					rw.Header()[k] = v
+				}
+				rw.WriteHeader(status)
+				rw.Write([]byte(`{"reason":"` + reason + `"}`))
+				return
+			}
+			rw.WriteHeader(http.StatusOK)
+		}))
+		return server, &calls
+	}
+
+	t.Run("idle timeout", func(t *testing.T) {
+		server, calls := newServer(1, http.StatusBadRequest, "IdleTimeout", nil)
+		defer server.Close()
+
+		var waits []time.Duration
+		c, err := New(
+			WithJWT(testPrivateKey, "key_id", "issuer"),
+			WithEndpoint(server.URL),
+			WithRetryPolicy(func(err error, attempt int) (bool, time.Duration) {
+				retry, wait := DefaultRetryPolicy(err, attempt)
+				waits = append(waits, wait)
+				return retry, wait
+			}),
+		)
+		assert.NoError(t, err)
+
+		_, err = c.Send(context.Background(), "test-token", Payload{})
+		assert.NoError(t, err)
+		assert.Equal(t, int32(2), calls.Load())
+		assert.Equal(t, []time.Duration{0}, waits)
+	})
+
+	t.Run("service unavailable", func(t *testing.T) {
+		server, calls := newServer(2, http.StatusServiceUnavailable, "ServiceUnavailable", nil)
+		defer server.Close()
+
+		var attempts []int
+		c, err := New(
+			WithJWT(testPrivateKey, "key_id", "issuer"),
+			WithEndpoint(server.URL),
+			WithRetryPolicy(func(err error, attempt int) (bool, time.Duration) {
+				attempts = append(attempts, attempt)
+				return true, time.Millisecond
+			}),
+		)
+		assert.NoError(t, err)
+
+		_, err = c.Send(context.Background(), "test-token", Payload{})
+		assert.NoError(t, err)
+		assert.Equal(t, int32(3), calls.Load())
+		assert.Equal(t, []int{1, 2}, attempts)
+	})
+
+	t.Run("too many requests honors retry-after", func(t *testing.T) {
+		server, calls := newServer(1, http.StatusTooManyRequests, "TooManyRequests", http.Header{"Retry-After": {"1"}})
+		defer server.Close()
+
+		c, err := New(
+			WithJWT(testPrivateKey, "key_id", "issuer"),
+			WithEndpoint(server.URL),
+			WithRetryPolicy(func(err error, attempt int) (bool, time.Duration) {
+				return attempt == 1, 0
+			}),
+		)
+		assert.NoError(t, err)
+
+		start := time.Now()
+		_, err = c.Send(context.Background(), "test-token", Payload{})
+		assert.NoError(t, err)
+		assert.Equal(t, int32(2), calls.Load())
+		assert.True(t, time.Since(start) >= time.Second)
+	})
+
+	t.Run("service unavailable honors retry-after", func(t *testing.T) {
+		date := time.Now().Add(1500 * time.Millisecond).UTC().Format(http.TimeFormat)
+		server, calls := newServer(1, http.StatusServiceUnavailable, "ServiceUnavailable", http.Header{"Retry-After": {date}})
+		defer server.Close()
+
+		c, err := New(
+			WithJWT(testPrivateKey, "key_id", "issuer"),
+			WithEndpoint(server.URL),
+			WithRetry(2),
+		)
+		assert.NoError(t, err)
+
+		start := time.Now()
+		_, err = c.Send(context.Background(), "test-token", Payload{})
+		assert.NoError(t, err)
+		assert.Equal(t, int32(2), calls.Load())
+		assert.True(t, time.Since(start) >= 400*time.Millisecond)
+
+		server, _ = newServer(1, http.StatusServiceUnavailable, "ServiceUnavailable", http.Header{"Retry-After": {"2"}})
+		defer server.Close()
+
+		c, err = New(WithJWT(testPrivateKey, "key_id", "issuer"), WithEndpoint(server.URL))
+		assert.NoError(t, err)
+		resp, err := c.Send(context.Background(), "test-token", Payload{})
+		assert.Error(t, err)
+		assert.Equal(t, 2*time.Second, resp.RetryAfter)
+		assert.Equal(t, StatusServerError, resp.Status())
+	})
+
+	t.Run("exhausted", func(t *testing.T) {
+		server, calls := newServer(10, http.StatusBadRequest, "IdleTimeout", nil)
+		defer server.Close()
+
+		c, err := New(
+			WithJWT(testPrivateKey, "key_id", "issuer"),
+			WithEndpoint(server.URL),
+			WithRetryPolicy(func(err error, attempt int) (bool, time.Duration) {
+				return attempt < 3, time.Millisecond
+			}),
+		)
+		assert.NoError(t, err)
+
+		_, err = c.Send(context.Background(), "test-token", Payload{})
+		assert.True(t, errors.Is(err, ErrIdleTimeout))
+		var exhausted *RetryExhaustedError
+		assert.True(t, errors.As(err, &exhausted))
+		assert.Equal(t, 3, exhausted.Attempts)
+		assert.Equal(t, ErrIdleTimeout, exhausted.LastError)
+		assert.True(t, exhausted.Elapsed >= 2*time.Millisecond)
+		assert.Equal(t, int32(3), calls.Load())
+	})
+
+	t.Run("not retried", func(t *testing.T) {
+		server, calls := newServer(1, http.StatusBadRequest, "BadDeviceToken", nil)
+		defer server.Close()
+
+		c, err := New(
+			WithJWT(testPrivateKey, "key_id", "issuer"),
+			WithEndpoint(server.URL),
+			WithRetryPolicy(DefaultRetryPolicy),
+		)
+		assert.NoError(t, err)
+
+		_, err = c.Send(context.Background(), "test-token", Payload{})
+		assert.Equal(t, ErrBadDeviceToken, err)
+		assert.Equal(t, int32(1), calls.Load())
+	})
+
+	t.Run("context done", func(t *testing.T) {
+		server, calls := newServer(10, http.StatusServiceUnavailable, "ServiceUnavailable", nil)
+		defer server.Close()
+
+		c, err := New(
+			WithJWT(testPrivateKey, "key_id", "issuer"),
+			WithEndpoint(server.URL),
+			WithRetryPolicy(func(err error, attempt int) (bool, time.Duration) {
+				return true, time.Hour
+			}),
+		)
+		assert.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err = c.Send(ctx, "test-token", Payload{})
+		assert.Error(t, err)
+		assert.Equal(t, int32(1), calls.Load())
+	})
+}
+
+func TestWithRetry(t *testing.T) {
+	var (
+		calls  atomic.Int32
+		bodies = make(chan string, 10)
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		bodies <- string(body)
+		switch {
+		case strings.HasSuffix(req.URL.Path, "/bad"):
+			rw.WriteHeader(http.StatusBadRequest)
+			rw.Write([]byte(`{"reason":"BadDeviceToken"}`))
+		case calls.Add(1) < 3:
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			rw.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer server.Close()
+
+	var logs syncBuffer
+	c, err := New(
+		WithJWT(testPrivateKey, "key_id", "issuer"),
+		WithEndpoint(server.URL),
+		WithRetry(3),
+		WithLogger(slog.New(slog.NewTextHandler(&logs, nil))),
+	)
+	assert.NoError(t, err)
+
+	_, err = c.Send(context.Background(), "test-token", Payload{APS: APS{Alert: Alert{Body: "hi"}}})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), calls.Load())
+	assert.Contains(t, logs.String(), `msg="apns: retrying notification" attempt=1 status_code=503`)
+	assert.Contains(t, logs.String(), `msg="apns: retrying notification" attempt=2 status_code=503`)
+	for i := 0; i < 3; i++ {
+		// The body is sent in full on each attempt.
+		assert.Equal(t, `{"aps":{"alert":{"body":"hi"}}}`, <-bodies)
+	}
+
+	_, err = c.Send(context.Background(), "bad", Payload{})
+	assert.Equal(t, ErrBadDeviceToken, err)
+	assert.Len(t, bodies, 1)
+
+	calls.Store(-10)
+	_, err = c.Send(context.Background(), "test-token", Payload{})
+	var exhausted *RetryExhaustedError
+	assert.True(t, errors.As(err, &exhausted))
+	assert.Equal(t, 3, exhausted.Attempts)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = c.Send(ctx, "test-token", Payload{})
+	assert.Error(t, err)
+
+	_, err = New(WithRetry(0))
+	assert.Error(t, err)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	assert.Equal(t, time.Duration(0), parseRetryAfter(""))
+	assert.Equal(t, 2*time.Second, parseRetryAfter("2"))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon"))
+	d := parseRetryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	assert.True(t, d > 58*time.Second && d <= time.Minute, d.String())
+}
+
+func TestSendOperationTimeout(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls.Add(1)
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	c, err := New(
+		WithJWT(testPrivateKey, "key_id", "issuer"),
+		WithEndpoint(server.URL),
+		WithRetryPolicy(func(err error, attempt int) (bool, time.Duration) {
+			return true, 20 * time.Millisecond
+		}),
+		WithOperationTimeout(100*time.Millisecond),
+	)
+	assert.NoError(t, err)
+
+	start := time.Now()
+	_, err = c.Send(context.Background(), "test-token", Payload{})
+	assert.Error(t, err)
+	assert.True(t, time.Since(start) < time.Second)
+	assert.True(t, calls.Load() > 1)
+}
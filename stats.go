@@ -0,0 +1,100 @@
+package apns
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// Stats holds counters of the notifications sent by the client.
+type Stats struct {
+	// Name is the name of the client set by [WithName].
+	Name string
+	// Sent is the number of notifications accepted by APNs.
+	Sent uint64
+	// Failed is the number of notifications, that were rejected or could not be sent.
+	Failed uint64
+	// Rejected is the number of failed notifications, that were rejected by APNs with a reason.
+	Rejected uint64
+	// ServerErrors is the number of failed notifications due to APNs server errors.
+	ServerErrors uint64
+	// ConnErrors is the number of failed notifications due to connection errors and timeouts.
+	ConnErrors uint64
+	// Renewals is the number of provider token renewals.
+	Renewals uint64
+	// Rate is the number of requests per second to APNs, measured over the last second.
+	Rate float64
+}
+
+type stats struct {
+	sent         atomic.Uint64
+	rejected     atomic.Uint64
+	serverErrors atomic.Uint64
+	connErrors   atomic.Uint64
+	renewals     atomic.Uint64
+	rate         rateMeter
+}
+
+func (s *stats) record(err error) {
+	var (
+		srvErr     serverError
+		connErr    *connError
+		timeoutErr timeoutError
+	)
+	switch {
+	case err == nil:
+		s.sent.Add(1)
+	case errors.As(err, &srvErr):
+		s.serverErrors.Add(1)
+	case errors.As(err, &connErr), errors.As(err, &timeoutErr),
+		errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		s.connErrors.Add(1)
+	default:
+		s.rejected.Add(1)
+	}
+}
+
+// Stats returns the counters of the notifications sent by the client.
+func (c *Client) Stats() Stats {
+	s := Stats{
+		Name:         c.name,
+		Sent:         c.stats.sent.Load(),
+		Rejected:     c.stats.rejected.Load(),
+		ServerErrors: c.stats.serverErrors.Load(),
+		ConnErrors:   c.stats.connErrors.Load(),
+		Renewals:     c.stats.renewals.Load(),
+		Rate:         c.stats.rate.current(),
+	}
+	s.Failed = s.Rejected + s.ServerErrors + s.ConnErrors
+	return s
+}
+
+// expvarMtx serializes publishing of expvar maps, as expvar.Publish panics on a name, that is already published.
+var expvarMtx sync.Mutex
+
+// publishExpvar publishes the counters of the client as an expvar map with the given name.
+func (c *Client) publishExpvar(name string) error {
+	expvarMtx.Lock()
+	defer expvarMtx.Unlock()
+	if expvar.Get(name) != nil {
+		return fmt.Errorf("expvar %q is already published", name)
+	}
+
+	m := new(expvar.Map).Init()
+	if c.name != "" {
+		name := new(expvar.String)
+		name.Set(c.name)
+		m.Set("name", name)
+	}
+	m.Set("sent", expvar.Func(func() any { return c.stats.sent.Load() }))
+	m.Set("rejected", expvar.Func(func() any { return c.stats.rejected.Load() }))
+	m.Set("server_errors", expvar.Func(func() any { return c.stats.serverErrors.Load() }))
+	m.Set("conn_errors", expvar.Func(func() any { return c.stats.connErrors.Load() }))
+	m.Set("renewals", expvar.Func(func() any { return c.stats.renewals.Load() }))
+	m.Set("rate", expvar.Func(func() any { return c.stats.rate.current() }))
+	expvar.Publish(name, m)
+	return nil
+}
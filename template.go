@@ -0,0 +1,81 @@
+package apns
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var placeholderRe = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_.-]+)\s*\}\}`)
+
+// Template is a payload with placeholders in the form of `{{name}}`, that are substituted with per-recipient
+// values on rendering. Placeholders are supported in the alert strings and loc args, and in the string values
+// of the custom values.
+type Template struct {
+	payload Payload
+}
+
+// NewTemplate creates new Template from the payload.
+func NewTemplate(p Payload) *Template {
+	return &Template{payload: p}
+}
+
+// Render returns a payload with all placeholders substituted with the values of vars. It fails if any placeholder
+// has no value. The template itself is not modified and can be rendered concurrently.
+func (t *Template) Render(vars map[string]string) (Payload, error) {
+	r := renderer{vars: vars}
+
+	p := t.payload
+	a := &p.APS.Alert
+	a.Title = r.render(a.Title)
+	a.Subtitle = r.render(a.Subtitle)
+	a.Body = r.render(a.Body)
+	a.TitleLocArgs = r.renderAll(a.TitleLocArgs)
+	a.SubtitleLocArgs = r.renderAll(a.SubtitleLocArgs)
+	a.LocArgs = r.renderAll(a.LocArgs)
+
+	if t.payload.CustomValues != nil {
+		p.CustomValues = make(map[string]any, len(t.payload.CustomValues))
+		for k, v := range t.payload.CustomValues {
+			if s, ok := v.(string); ok {
+				v = r.render(s)
+			}
+			p.CustomValues[k] = v
+		}
+	}
+
+	if len(r.missing) > 0 {
+		sort.Strings(r.missing)
+		return Payload{}, fmt.Errorf("unresolved template placeholders: %s", strings.Join(r.missing, ", "))
+	}
+	return p, nil
+}
+
+type renderer struct {
+	vars    map[string]string
+	missing []string
+}
+
+func (r *renderer) render(s string) string {
+	return placeholderRe.ReplaceAllStringFunc(s, func(m string) string {
+		name := placeholderRe.FindStringSubmatch(m)[1]
+		v, ok := r.vars[name]
+		if !ok {
+			r.missing = append(r.missing, name)
+			return m
+		}
+		return v
+	})
+}
+
+func (r *renderer) renderAll(ss []string) []string {
+	if ss == nil {
+		return nil
+	}
+	out := make([]string, len(ss))
+	for i, s := range ss {
+		out[i] = r.render(s)
+	}
+	return out
+}
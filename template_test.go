@@ -0,0 +1,33 @@
+package apns
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplateRender(t *testing.T) {
+	tmpl := NewTemplate(Payload{
+		APS: APS{
+			Alert: Alert{
+				Title:   "Hi {{name}}",
+				Body:    "You have {{ count }} new messages",
+				LocArgs: []string{"{{name}}"},
+			},
+		},
+		CustomValues: map[string]any{"campaign": "{{campaign}}", "version": 2},
+	})
+
+	p, err := tmpl.Render(map[string]string{"name": "Ann", "count": "3", "campaign": "spring"})
+	assert.NoError(t, err)
+	assert.Equal(t, p.APS.Alert.Title, "Hi Ann")
+	assert.Equal(t, p.APS.Alert.Body, "You have 3 new messages")
+	assert.Equal(t, p.APS.Alert.LocArgs, []string{"Ann"})
+	assert.Equal(t, p.CustomValues, map[string]any{"campaign": "spring", "version": 2})
+
+	// The template is not modified by rendering.
+	assert.Equal(t, tmpl.payload.APS.Alert.LocArgs, []string{"{{name}}"})
+
+	_, err = tmpl.Render(map[string]string{"name": "Ann"})
+	assert.EqualError(t, err, "unresolved template placeholders: campaign, count")
+}
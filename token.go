@@ -0,0 +1,177 @@
+package apns
+
+import (
+	"context"
+	"crypto/elliptic"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// TokenSource issues and caches provider tokens signed by a single private key.
+type TokenSource struct {
+	config *JWTConfig
+
+	mtx   sync.RWMutex
+	token string
+}
+
+// NewTokenSource creates new TokenSource for the given private key, key ID and team ID, and issues the first token.
+func NewTokenSource(privateKey []byte, keyID string, teamID string) (*TokenSource, error) {
+	key, err := parsePrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	s := &TokenSource{
+		config: &JWTConfig{
+			PrivateKey: key,
+			KeyID:      keyID,
+			Issuer:     teamID,
+		},
+	}
+	if err := s.Renew(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Token returns the current provider token.
+func (s *TokenSource) Token() (string, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	if s.token == "" {
+		return "", errors.New("token is not issued")
+	}
+	return s.token, nil
+}
+
+// Renew issues a new provider token. On failure the previous token is kept.
+func (s *TokenSource) Renew() error {
+	token, _, err := issueToken(s.config, defaultTokenValidityInterval)
+	if err != nil {
+		return err
+	}
+
+	s.mtx.Lock()
+	s.token = token
+	s.mtx.Unlock()
+	return nil
+}
+
+// TokenManager holds token sources of multiple teams and renews them on a shared schedule. It allows to serve
+// many APNs keys without running a renewal goroutine for every single client. Clients look up their token
+// on each send, see [WithTokenManager].
+type TokenManager struct {
+	mtx     sync.RWMutex
+	sources map[string]*TokenSource
+}
+
+// NewTokenManager creates new empty TokenManager.
+func NewTokenManager() *TokenManager {
+	return &TokenManager{
+		sources: make(map[string]*TokenSource),
+	}
+}
+
+// Add registers the token source for the given team ID, replacing the previous one if any.
+func (m *TokenManager) Add(teamID string, s *TokenSource) {
+	m.mtx.Lock()
+	m.sources[teamID] = s
+	m.mtx.Unlock()
+}
+
+// Remove unregisters the token source of the given team ID.
+func (m *TokenManager) Remove(teamID string) {
+	m.mtx.Lock()
+	delete(m.sources, teamID)
+	m.mtx.Unlock()
+}
+
+// Token returns the current provider token of the given team ID.
+func (m *TokenManager) Token(teamID string) (string, error) {
+	m.mtx.RLock()
+	s, ok := m.sources[teamID]
+	m.mtx.RUnlock()
+
+	if !ok {
+		return "", fmt.Errorf("unknown team ID %q", teamID)
+	}
+	return s.Token()
+}
+
+// RenewAll renews tokens of all registered sources. It returns the joined errors of the sources that failed
+// to renew, these sources keep their previous tokens.
+func (m *TokenManager) RenewAll() error {
+	m.mtx.RLock()
+	sources := make(map[string]*TokenSource, len(m.sources))
+	for teamID, s := range m.sources {
+		sources[teamID] = s
+	}
+	m.mtx.RUnlock()
+
+	var errs []error
+	for teamID, s := range sources {
+		if err := s.Renew(); err != nil {
+			errs = append(errs, fmt.Errorf("renew token of team %q: %w", teamID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Run renews tokens of all registered sources every interval until the context is done. Failed renewals are
+// retried on the next tick.
+func (m *TokenManager) Run(ctx context.Context, interval time.Duration) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			_ = m.RenewAll()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// issueToken signs a new provider token, that expires after the validity, and returns it with its issue time.
+func issueToken(config *JWTConfig, validity time.Duration) (string, time.Time, error) {
+	method := config.SigningMethod
+	if method == nil {
+		var err error
+		if method, err = signingMethodFor(config.PrivateKey.Curve); err != nil {
+			return "", time.Time{}, err
+		}
+	}
+
+	tNow := time.Now().UTC()
+	token := jwt.NewWithClaims(method, jwt.RegisteredClaims{
+		Issuer:    config.Issuer,
+		IssuedAt:  jwt.NewNumericDate(tNow),
+		ExpiresAt: jwt.NewNumericDate(tNow.Add(validity)),
+	})
+	token.Header["kid"] = config.KeyID
+
+	t, err := token.SignedString(config.PrivateKey)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return t, tNow, nil
+}
+
+// signingMethodFor returns the signing method matching the curve of the private key.
+func signingMethodFor(curve elliptic.Curve) (jwt.SigningMethod, error) {
+	switch curve {
+	case elliptic.P256():
+		return jwt.SigningMethodES256, nil
+	case elliptic.P384():
+		return jwt.SigningMethodES384, nil
+	case elliptic.P521():
+		return jwt.SigningMethodES512, nil
+	}
+	return nil, fmt.Errorf("unsupported curve %s of private key", curve.Params().Name)
+}
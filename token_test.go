@@ -0,0 +1,376 @@
+package apns
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenManager(t *testing.T) {
+	s, err := NewTokenSource(testPrivateKey, "key_id", "team_id")
+	assert.NoError(t, err)
+
+	m := NewTokenManager()
+	m.Add("team_id", s)
+
+	token, err := m.Token("team_id")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, token)
+
+	assert.NoError(t, m.RenewAll())
+
+	_, err = m.Token("unknown")
+	assert.Error(t, err)
+
+	m.Remove("team_id")
+	_, err = m.Token("team_id")
+	assert.Error(t, err)
+}
+
+func TestTokenManagerRun(t *testing.T) {
+	s, err := NewTokenSource(testPrivateKey, "key_id", "team_id")
+	assert.NoError(t, err)
+
+	m := NewTokenManager()
+	m.Add("team_id", s)
+	initial, err := m.Token("team_id")
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		m.Run(ctx, 10*time.Millisecond)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		token, err := m.Token("team_id")
+		assert.NoError(t, err)
+		if token != initial {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("token was not renewed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after the context was cancelled")
+	}
+}
+
+func TestWithTokenManagerAndJWT(t *testing.T) {
+	_, err := NewClient(
+		context.Background(),
+		WithJWT(testPrivateKey, "key_id", "issuer"),
+		WithTokenManager(NewTokenManager(), "team_id"),
+	)
+	assert.Error(t, err)
+}
+
+func TestDecodeCurrentToken(t *testing.T) {
+	c, err := NewClient(context.Background(), WithJWT(testPrivateKey, "key_id", "team_id"))
+	assert.NoError(t, err)
+
+	header, claims, err := c.DecodeCurrentToken()
+	assert.NoError(t, err)
+	assert.Equal(t, header["kid"], "key_id")
+	assert.Equal(t, header["alg"], "ES256")
+	assert.Equal(t, claims["iss"], "team_id")
+	assert.Contains(t, claims, "iat")
+	assert.Contains(t, claims, "exp")
+
+	c, err = NewClient(context.Background())
+	assert.NoError(t, err)
+	_, _, err = c.DecodeCurrentToken()
+	assert.Error(t, err)
+}
+
+func TestNextSafeRefresh(t *testing.T) {
+	c, err := New(WithJWT(testPrivateKey, "key_id", "team_id"))
+	assert.NoError(t, err)
+	assert.True(t, c.NextSafeRefresh().IsZero())
+
+	_, err = c.Token()
+	assert.NoError(t, err)
+	issuedAt := c.tokenIssuedAt
+	assert.Equal(t, issuedAt.Add(20*time.Minute), c.NextSafeRefresh())
+
+	c.tokenIssuedAt = time.Now().Add(-30 * time.Minute)
+	assert.True(t, time.Since(c.NextSafeRefresh()) < time.Second)
+
+	c, err = New()
+	assert.NoError(t, err)
+	assert.True(t, c.NextSafeRefresh().IsZero())
+}
+
+func TestKeyIDAndTeamID(t *testing.T) {
+	c, err := New(WithJWT(testPrivateKey, "key_id", "team_id"))
+	assert.NoError(t, err)
+	assert.Equal(t, "key_id", c.KeyID())
+	assert.Equal(t, "team_id", c.TeamID())
+
+	m := NewTokenManager()
+	c, err = New(WithTokenManager(m, "other_team"))
+	assert.NoError(t, err)
+	assert.Equal(t, "", c.KeyID())
+	assert.Equal(t, "other_team", c.TeamID())
+}
+
+type recordingBackoff struct {
+	attempts chan int
+}
+
+func (b recordingBackoff) Next(attempt int) time.Duration {
+	b.attempts <- attempt
+	return time.Millisecond
+}
+
+func TestWithTokenRenewBackoff(t *testing.T) {
+	b := recordingBackoff{attempts: make(chan int, 10)}
+	c, err := New(WithJWT(testPrivateKey, "key_id", "team_id"), WithTokenRenewBackoff(b), WithTokenRenewInterval(time.Millisecond))
+	assert.NoError(t, err)
+	_, err = c.Token()
+	assert.NoError(t, err)
+
+	// The key with zero scalar can not sign, so each renewal fails.
+	validKey := c.jwtConfig.PrivateKey
+	c.jwtConfig = &JWTConfig{
+		PrivateKey: &ecdsa.PrivateKey{PublicKey: validKey.PublicKey, D: big.NewInt(0)},
+		KeyID:      "key_id",
+		Issuer:     "team_id",
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.renewToken(ctx)
+	for i := 1; i <= 3; i++ {
+		assert.Equal(t, i, <-b.attempts)
+	}
+	// Only the first issuance is counted.
+	assert.Equal(t, uint64(1), c.Stats().Renewals)
+}
+
+func TestWithTokenErrorHandler(t *testing.T) {
+	errs := make(chan error, 10)
+	var logs syncBuffer
+	c, err := New(
+		WithJWT(testPrivateKey, "key_id", "team_id"),
+		WithTokenErrorHandler(func(err error) { errs <- err }),
+		WithTokenRenewInterval(time.Millisecond),
+		WithLogger(slog.New(slog.NewTextHandler(&logs, nil))),
+	)
+	assert.NoError(t, err)
+	token, err := c.Token()
+	assert.NoError(t, err)
+
+	validKey := c.jwtConfig.PrivateKey
+	c.jwtConfig = &JWTConfig{
+		PrivateKey: &ecdsa.PrivateKey{PublicKey: validKey.PublicKey, D: big.NewInt(0)},
+		KeyID:      "key_id",
+		Issuer:     "team_id",
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go c.renewToken(ctx)
+	// The renewal is retried on the next tick.
+	assert.Error(t, <-errs)
+	assert.Error(t, <-errs)
+	cancel()
+
+	c.mtx.Lock()
+	assert.Equal(t, token, c.token)
+	c.mtx.Unlock()
+	assert.Contains(t, logs.String(), `msg="apns: failed to renew provider token" key_id=key_id attempt=1`)
+}
+
+func TestTokenRenewalSchedule(t *testing.T) {
+	c, err := New(WithJWT(testPrivateKey, "key_id", "team_id"))
+	assert.NoError(t, err)
+	next := c.nextRenewal()
+	assert.True(t, next > 53*time.Minute && next <= 54*time.Minute, next)
+
+	// The validity applies to the first token regardless of the order of the options.
+	c, err = New(WithTokenValidity(30*time.Minute), WithJWT(testPrivateKey, "key_id", "team_id"))
+	assert.NoError(t, err)
+	next = c.nextRenewal()
+	assert.True(t, next > 26*time.Minute && next <= 27*time.Minute, next)
+	_, claims, err := c.DecodeCurrentToken()
+	assert.NoError(t, err)
+	assert.Equal(t, claims["exp"].(float64)-claims["iat"].(float64), float64(30*60))
+
+	c, err = New(WithJWT(testPrivateKey, "key_id", "team_id"), WithTokenRenewInterval(5*time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, c.nextRenewal(), 5*time.Minute)
+
+	_, err = New(WithTokenValidity(2 * time.Hour))
+	assert.Error(t, err)
+	_, err = New(WithTokenValidity(30*time.Minute), WithTokenRenewInterval(30*time.Minute))
+	assert.Error(t, err)
+}
+
+func TestSigningMethod(t *testing.T) {
+	newKey := func(curve elliptic.Curve) []byte {
+		key, err := ecdsa.GenerateKey(curve, rand.Reader)
+		assert.NoError(t, err)
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		assert.NoError(t, err)
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	}
+
+	for curve, alg := range map[elliptic.Curve]string{
+		elliptic.P256(): "ES256",
+		elliptic.P384(): "ES384",
+		elliptic.P521(): "ES512",
+	} {
+		c, err := New(WithJWT(newKey(curve), "key_id", "team_id"))
+		assert.NoError(t, err)
+		header, _, err := c.DecodeCurrentToken()
+		assert.NoError(t, err)
+		assert.Equal(t, alg, header["alg"])
+	}
+
+	_, err := New(WithJWT(newKey(elliptic.P224()), "key_id", "team_id"))
+	assert.EqualError(t, err, "unsupported curve P-224 of private key")
+
+	// The method does not match the curve of the key.
+	_, err = New(WithJWT(testPrivateKey, "key_id", "team_id"), WithSigningMethod(jwt.SigningMethodES384))
+	assert.EqualError(t, err, "signing method ES384 does not match curve P-256 of private key")
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	der, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+	parsed, err := parsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+	assert.NoError(t, err)
+	assert.True(t, key.Equal(parsed))
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.NoError(t, err)
+	der = x509.MarshalPKCS1PrivateKey(rsaKey)
+	_, err = parsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: der}))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "parse private key as PKCS#8: ")
+	assert.Contains(t, err.Error(), ", as SEC1: ")
+
+	der, err = x509.MarshalPKCS8PrivateKey(rsaKey)
+	assert.NoError(t, err)
+	_, err = parsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	assert.EqualError(t, err, "not ECDSA private key")
+}
+
+func TestTokenIssuedOnFirstSend(t *testing.T) {
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		auth = req.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	var (
+		renewed []string
+		logs    bytes.Buffer
+	)
+	c, err := New(
+		WithJWT(testPrivateKey, "key_id", "team_id"),
+		WithEndpoint(server.URL),
+		WithTokenRenewedHandler(func(token string, _ time.Time) { renewed = append(renewed, token) }),
+		WithLogger(slog.New(slog.NewTextHandler(&logs, nil))),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, "", c.token)
+
+	for i := 0; i < 2; i++ {
+		_, err = c.Send(context.Background(), "token", Payload{})
+		assert.NoError(t, err)
+	}
+	assert.NotEmpty(t, c.token)
+	assert.Equal(t, "bearer "+c.token, auth)
+
+	// The first issuance is reported as a renewal.
+	assert.Equal(t, []string{c.token}, renewed)
+	assert.Equal(t, uint64(1), c.Stats().Renewals)
+	assert.Contains(t, logs.String(), `msg="apns: provider token is renewed" key_id=key_id`)
+}
+
+func TestClientToken(t *testing.T) {
+	c, err := New(WithJWT(testPrivateKey, "key_id", "team_id"))
+	assert.NoError(t, err)
+	token, err := c.Token()
+	assert.NoError(t, err)
+	assert.Equal(t, c.token, token)
+
+	// No token is issued yet.
+	c.token = ""
+	token, err = c.Token()
+	assert.NoError(t, err)
+	assert.NotEmpty(t, token)
+	assert.Equal(t, c.token, token)
+
+	c, err = New()
+	assert.NoError(t, err)
+	_, err = c.Token()
+	assert.EqualError(t, err, "JWT is not configured")
+}
+
+func TestWithJWTFromFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "AuthKey_ABC.p8")
+	assert.NoError(t, os.WriteFile(path, testPrivateKey, 0o600))
+
+	c, err := New(WithJWTFromFile(path, "ABC", "team_id"))
+	assert.NoError(t, err)
+	assert.Equal(t, "ABC", c.KeyID())
+
+	missing := filepath.Join(dir, "missing.p8")
+	_, err = New(WithJWTFromFile(missing, "ABC", "team_id"))
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+	assert.Contains(t, err.Error(), fmt.Sprintf("read auth key %q: ", missing))
+
+	assert.NoError(t, os.WriteFile(path, []byte("garbage"), 0o600))
+	_, err = New(WithJWTFromFile(path, "ABC", "team_id"))
+	assert.EqualError(t, err, fmt.Sprintf("parse auth key %q: not PEM encoded key", path))
+}
+
+// syncBuffer is a bytes.Buffer, that is safe to write from the goroutines of the client.
+type syncBuffer struct {
+	mtx sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.buf.String()
+}
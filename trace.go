@@ -0,0 +1,93 @@
+package apns
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// ConnTrace holds connection diagnostics of a single request, collected when [WithConnTrace] is used.
+type ConnTrace struct {
+	// Reused reports whether the request was sent over a previously used connection.
+	Reused bool
+	// WasIdle reports whether the connection was taken from the idle pool.
+	WasIdle bool
+	// DNS is the duration of the DNS lookup, zero if there was no lookup.
+	DNS time.Duration
+	// Connect is the duration of establishing a new TCP connection, zero if the connection was reused.
+	Connect time.Duration
+	// TLSHandshake is the duration of the TLS handshake, zero if the connection was reused.
+	TLSHandshake time.Duration
+	// FirstByte is the duration from the start of the request to the first byte of the response.
+	FirstByte time.Duration
+}
+
+type connTracer struct {
+	mtx   sync.Mutex
+	start time.Time
+	trace ConnTrace
+
+	dnsStart     time.Time
+	connectStart time.Time
+	tlsStart     time.Time
+}
+
+// withConnTrace returns a context, that collects connection diagnostics of the request it is used by.
+func withConnTrace(ctx context.Context) (context.Context, *connTracer) {
+	t := &connTracer{start: time.Now()}
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.mtx.Lock()
+			t.dnsStart = time.Now()
+			t.mtx.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.mtx.Lock()
+			t.trace.DNS = time.Since(t.dnsStart)
+			t.mtx.Unlock()
+		},
+		ConnectStart: func(string, string) {
+			t.mtx.Lock()
+			if t.connectStart.IsZero() {
+				t.connectStart = time.Now()
+			}
+			t.mtx.Unlock()
+		},
+		ConnectDone: func(string, string, error) {
+			t.mtx.Lock()
+			t.trace.Connect = time.Since(t.connectStart)
+			t.mtx.Unlock()
+		},
+		TLSHandshakeStart: func() {
+			t.mtx.Lock()
+			t.tlsStart = time.Now()
+			t.mtx.Unlock()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.mtx.Lock()
+			t.trace.TLSHandshake = time.Since(t.tlsStart)
+			t.mtx.Unlock()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mtx.Lock()
+			t.trace.Reused = info.Reused
+			t.trace.WasIdle = info.WasIdle
+			t.mtx.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			t.mtx.Lock()
+			t.trace.FirstByte = time.Since(t.start)
+			t.mtx.Unlock()
+		},
+	}), t
+}
+
+func (t *connTracer) result() *ConnTrace {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	trace := t.trace
+	return &trace
+}
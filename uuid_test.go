@@ -0,0 +1,24 @@
+package apns
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUUID(t *testing.T) {
+	u, err := ParseUUID("123E4567-e89b-12d3-a456-426655440000")
+	assert.NoError(t, err)
+	assert.Equal(t, u.String(), "123e4567-e89b-12d3-a456-426655440000")
+
+	for _, s := range []string{"", "123e4567e89b12d3a456426655440000", "123e4567-e89b-12d3-a456-42665544000z"} {
+		_, err := ParseUUID(s)
+		assert.Error(t, err)
+	}
+
+	u = NewUUID()
+	assert.NotEqual(t, u, NewUUID())
+	parsed, err := ParseUUID(u.String())
+	assert.NoError(t, err)
+	assert.Equal(t, parsed, u)
+}